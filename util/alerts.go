@@ -0,0 +1,741 @@
+package toputils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+const (
+	// RoutePendingSamples is the number of consecutive polls in which
+	// the pending bytes of a route have to grow before alerting.
+	RoutePendingSamples = 3
+
+	// RouteFlapChanges is the number of changes in the set of routes
+	// within RouteFlapWindow which are considered to be flapping.
+	RouteFlapChanges = 3
+	RouteFlapWindow  = time.Minute
+
+	// RouteSubsJump is the change in the subscriptions of a route between
+	// polls which is alerted about for RouteSubsJumpWindow, since it often
+	// means an interest storm caused by a misbehaving client.
+	RouteSubsJump       = 1000
+	RouteSubsJumpWindow = time.Minute
+
+	// RestartWindow is how long the restart of a server is alerted
+	// about after noticing its uptime decreased.
+	RestartWindow = 5 * time.Minute
+
+	// StormWindow is how long a reconnect storm is alerted about after
+	// noticing many connections started since the previous poll, and
+	// StormTopGroups the number of client versions and subnets listed.
+	StormWindow    = time.Minute
+	StormTopGroups = 3
+
+	// ConnSubsSamples is the number of consecutive polls in which the
+	// subscriptions of a connection have to grow before alerting.
+	ConnSubsSamples = 10
+
+	// ConsumerLagSamples is the number of consecutive polls in which the
+	// messages pending for a consumer have to grow before alerting.
+	ConsumerLagSamples = 10
+
+	// ConsumerRedeliveredSamples is the number of consecutive polls in
+	// which the redeliveries of a consumer have to grow before alerting.
+	ConsumerRedeliveredSamples = 10
+)
+
+// Alert represents a condition detected from the polled stats
+// which requires the attention of the operator. Target is set for
+// conditions which can fire for more than one element, e.g. a route.
+type Alert struct {
+	Condition string    `json:"condition"`
+	Target    string    `json:"target,omitempty"`
+	Message   string    `json:"message"`
+	Since     time.Time `json:"since"`
+}
+
+// AlertEvent is a change in the state of an alert,
+// either starting to fire or being resolved.
+type AlertEvent struct {
+	Time     time.Time
+	Alert    *Alert
+	Resolved bool
+}
+
+// routePending tracks the growth of pending bytes of a route.
+type routePending struct {
+	last   int
+	growth int
+	since  time.Time
+}
+
+// checkRoutes compares the pending bytes of each route against the
+// previous polls and alerts in case they have been growing persistently.
+func (engine *Engine) checkRoutes(routez *gnatsd.Routez, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	tracked := make(map[uint64]*routePending)
+	for _, route := range routez.Routes {
+		rp, ok := engine.routesPending[route.Rid]
+		if !ok {
+			rp = &routePending{last: route.Pending}
+		}
+
+		if route.Pending > rp.last {
+			if rp.growth == 0 {
+				rp.since = now
+			}
+			rp.growth++
+		} else if route.Pending < rp.last || route.Pending == 0 {
+			rp.growth = 0
+		}
+		rp.last = route.Pending
+		tracked[route.Rid] = rp
+
+		if rp.growth >= RoutePendingSamples {
+			alerts = append(alerts, &Alert{
+				Condition: "route_pending",
+				Target:    fmt.Sprintf("%d", route.Rid),
+				Message: fmt.Sprintf("route %d to %s:%d pending grew to %s over %d polls",
+					route.Rid, route.IP, route.Port, Psize(int64(route.Pending)), rp.growth),
+				Since: rp.since,
+			})
+		}
+	}
+
+	// Routes that are gone no longer need to be tracked
+	engine.routesPending = tracked
+
+	return alerts
+}
+
+// checkRouteCount compares the number of routes against the expected
+// cluster size and alerts in case routes are missing or flapping.
+func (engine *Engine) checkRouteCount(routez *gnatsd.Routez, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	if engine.ClusterSize > 1 {
+		expected := engine.ClusterSize - 1
+		if missing := expected - len(routez.Routes); missing > 0 {
+			if engine.routesMissingSince.IsZero() {
+				engine.routesMissingSince = now
+			}
+			alerts = append(alerts, &Alert{
+				Condition: "route_missing",
+				Message: fmt.Sprintf("%d of %d routes missing for %s", missing, expected,
+					now.Sub(engine.routesMissingSince)/time.Second*time.Second),
+				Since: engine.routesMissingSince,
+			})
+		} else {
+			engine.routesMissingSince = time.Time{}
+		}
+	}
+
+	// Any route which is new or gone since the last poll is a change,
+	// ignoring the first poll which has nothing to compare against.
+	ids := make(map[uint64]struct{})
+	changed := false
+	for _, route := range routez.Routes {
+		ids[route.Rid] = struct{}{}
+		if _, ok := engine.routeIDs[route.Rid]; !ok {
+			changed = true
+		}
+	}
+	if len(ids) != len(engine.routeIDs) {
+		changed = true
+	}
+	if changed && engine.routeIDs != nil {
+		engine.routeChanges = append(engine.routeChanges, now)
+	}
+	engine.routeIDs = ids
+
+	var recent []time.Time
+	for _, t := range engine.routeChanges {
+		if now.Sub(t) <= RouteFlapWindow {
+			recent = append(recent, t)
+		}
+	}
+	engine.routeChanges = recent
+
+	if len(recent) >= RouteFlapChanges {
+		alerts = append(alerts, &Alert{
+			Condition: "route_flapping",
+			Message:   fmt.Sprintf("routes flapping, changed %d times in the last %s", len(recent), RouteFlapWindow),
+			Since:     recent[0],
+		})
+	}
+
+	return alerts
+}
+
+// routeSubsJump is the last large change in the subscriptions of a route.
+type routeSubsJump struct {
+	delta int64
+	subs  uint32
+	at    time.Time
+}
+
+// checkRouteSubs alerts in case the subscriptions of a route changed
+// by RouteSubsJump or more between polls, for RouteSubsJumpWindow.
+func (engine *Engine) checkRouteSubs(routes []*RouteSubs, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	jumps := make(map[uint64]*routeSubsJump)
+	for _, route := range routes {
+		jump := engine.routeSubsJumps[route.Rid]
+		if route.Delta >= RouteSubsJump || route.Delta <= -RouteSubsJump {
+			jump = &routeSubsJump{delta: route.Delta, subs: route.Subs, at: now}
+		}
+		if jump == nil || now.Sub(jump.at) > RouteSubsJumpWindow {
+			continue
+		}
+		jumps[route.Rid] = jump
+
+		alerts = append(alerts, &Alert{
+			Condition: "route_subs_jump",
+			Target:    fmt.Sprintf("%d", route.Rid),
+			Message: fmt.Sprintf("route %d to %s subscriptions changed by %+d to %d",
+				route.Rid, route.Remote, jump.delta, jump.subs),
+			Since: jump.at,
+		})
+	}
+
+	// Routes that are gone no longer need to be tracked
+	engine.routeSubsJumps = jumps
+
+	return alerts
+}
+
+// alertSince returns the time when a condition started firing,
+// forgetting about it once it is no longer firing.
+func (engine *Engine) alertSince(condition string, firing bool, now time.Time) time.Time {
+	if engine.alertsSince == nil {
+		engine.alertsSince = make(map[string]time.Time)
+	}
+	if !firing {
+		delete(engine.alertsSince, condition)
+		return time.Time{}
+	}
+	since, ok := engine.alertsSince[condition]
+	if !ok {
+		since = now
+		engine.alertsSince[condition] = since
+	}
+	return since
+}
+
+// checkJetStream alerts in case the memory or file storage used by
+// JetStream are above the percentage threshold of the configured limits.
+func (engine *Engine) checkJetStream(jsz *Jsz, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	if jsz == nil || jsz.Disabled || jsz.Config == nil {
+		return alerts
+	}
+
+	usage := []struct {
+		condition string
+		name      string
+		used      uint64
+		limit     int64
+	}{
+		{"js_memory", "memory", jsz.Memory, jsz.Config.MaxMemory},
+		{"js_storage", "file storage", jsz.Store, jsz.Config.MaxStore},
+	}
+	for _, u := range usage {
+		var pct float64
+		if u.limit > 0 {
+			pct = float64(u.used) / float64(u.limit) * 100
+		}
+		firing := u.limit > 0 && pct >= engine.JetStreamThreshold
+		since := engine.alertSince(u.condition, firing, now)
+		if firing {
+			alerts = append(alerts, &Alert{
+				Condition: u.condition,
+				Message: fmt.Sprintf("JetStream %s usage at %.1f%% of %s limit",
+					u.name, pct, Psize(u.limit)),
+				Since: since,
+			})
+		}
+	}
+
+	return alerts
+}
+
+// checkPollDuration alerts in case polling the server took longer than
+// the refresh interval, so that the next poll starts late and the rates
+// are measured over a longer interval than the one requested.
+func (engine *Engine) checkPollDuration(took time.Duration, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	interval := time.Duration(engine.Delay) * time.Second
+	firing := took > interval
+	since := engine.alertSince("slow_poll", firing, now)
+	if firing {
+		alerts = append(alerts, &Alert{
+			Condition: "slow_poll",
+			Message: fmt.Sprintf("polling took %s, longer than the %s refresh interval",
+				took/time.Millisecond*time.Millisecond, interval),
+			Since: since,
+		})
+	}
+
+	return alerts
+}
+
+// connStorm is a burst of connections noticed in a poll.
+type connStorm struct {
+	message string
+	since   time.Time
+	at      time.Time
+}
+
+// checkConnStorm alerts in case the number of polled connections which
+// started since the previous poll reaches the storm threshold, listing
+// the client versions and subnets most of them come from.
+func (engine *Engine) checkConnStorm(connz *gnatsd.Connz, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	last := engine.lastConnzNow
+	engine.lastConnzNow = connz.Now
+	if engine.StormConns <= 0 {
+		return alerts
+	}
+
+	if !last.IsZero() {
+		clients := make(map[string]int)
+		subnets := make(map[string]int)
+		started := 0
+		for _, conn := range connz.Conns {
+			if !conn.Start.After(last) {
+				continue
+			}
+			started++
+			clients[strings.TrimSpace(conn.Lang+" "+conn.Version)]++
+			subnets[subnet(conn.IP)]++
+		}
+
+		if started >= engine.StormConns {
+			storm := engine.storm
+			if storm == nil {
+				storm = &connStorm{since: now}
+			}
+			storm.at = now
+			storm.message = fmt.Sprintf("reconnect storm: %d connections started in %s (clients: %s; subnets: %s)",
+				started, connz.Now.Sub(last)/time.Second*time.Second,
+				topCounts(clients, StormTopGroups), topCounts(subnets, StormTopGroups))
+			engine.storm = storm
+		}
+	}
+
+	if engine.storm == nil || now.Sub(engine.storm.at) > StormWindow {
+		engine.storm = nil
+		return alerts
+	}
+	alerts = append(alerts, &Alert{
+		Condition: "conn_storm",
+		Message:   engine.storm.message,
+		Since:     engine.storm.since,
+	})
+
+	return alerts
+}
+
+// connSubsGrowth tracks the growth of the subscriptions of a connection.
+type connSubsGrowth struct {
+	last      uint32
+	growth    int
+	since     time.Time
+	overSince time.Time
+}
+
+// checkConnSubs alerts in case a polled connection has more
+// subscriptions than the threshold, or its subscriptions have been
+// growing persistently, since either often means a subscription leak.
+// Both are disabled unless the threshold is set.
+func (engine *Engine) checkConnSubs(connz *gnatsd.Connz, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	if engine.ConnSubs <= 0 {
+		engine.connSubs = nil
+		return alerts
+	}
+
+	tracked := make(map[uint64]*connSubsGrowth)
+	for _, conn := range connz.Conns {
+		cs, ok := engine.connSubs[conn.Cid]
+		if !ok {
+			cs = &connSubsGrowth{last: conn.NumSubs}
+		}
+
+		if conn.NumSubs > cs.last {
+			if cs.growth == 0 {
+				cs.since = now
+			}
+			cs.growth++
+		} else {
+			cs.growth = 0
+		}
+		cs.last = conn.NumSubs
+		tracked[conn.Cid] = cs
+
+		name := fmt.Sprintf("connection %d", conn.Cid)
+		if conn.Name != "" {
+			name += fmt.Sprintf(" (%s)", conn.Name)
+		}
+		over := conn.NumSubs > uint32(engine.ConnSubs)
+		if !over {
+			cs.overSince = time.Time{}
+		} else if cs.overSince.IsZero() {
+			cs.overSince = now
+		}
+		switch {
+		case over:
+			alerts = append(alerts, &Alert{
+				Condition: "conn_subs",
+				Target:    fmt.Sprintf("%d", conn.Cid),
+				Message:   fmt.Sprintf("%s has %d subscriptions", name, conn.NumSubs),
+				Since:     cs.overSince,
+			})
+		case cs.growth >= ConnSubsSamples:
+			alerts = append(alerts, &Alert{
+				Condition: "conn_subs",
+				Target:    fmt.Sprintf("%d", conn.Cid),
+				Message: fmt.Sprintf("%s subscriptions grew to %d over %d polls",
+					name, conn.NumSubs, cs.growth),
+				Since: cs.since,
+			})
+		}
+	}
+
+	// Connections that are gone no longer need to be tracked
+	engine.connSubs = tracked
+
+	return alerts
+}
+
+// consumerLagGrowth tracks the growth of the messages pending for a consumer.
+type consumerLagGrowth struct {
+	last      uint64
+	growth    int
+	since     time.Time
+	overSince time.Time
+}
+
+// checkConsumerLag alerts in case a JetStream consumer has more messages
+// pending than the threshold, or its pending messages have been growing
+// persistently, since either means it is falling behind its stream.
+// Both are disabled unless the threshold is set.
+func (engine *Engine) checkConsumerLag(jsz *Jsz, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	if engine.ConsumerLag <= 0 || jsz == nil {
+		engine.consumerLag = nil
+		return alerts
+	}
+
+	tracked := make(map[string]*consumerLagGrowth)
+	for _, acc := range jsz.AccountDetails {
+		for _, stream := range acc.Streams {
+			for _, consumer := range stream.Consumers {
+				target := fmt.Sprintf("%s/%s/%s", acc.Name, stream.Name, consumer.Name)
+				cl, ok := engine.consumerLag[target]
+				if !ok {
+					cl = &consumerLagGrowth{last: consumer.NumPending}
+				}
+
+				if consumer.NumPending > cl.last {
+					if cl.growth == 0 {
+						cl.since = now
+					}
+					cl.growth++
+				} else {
+					cl.growth = 0
+				}
+				cl.last = consumer.NumPending
+				tracked[target] = cl
+
+				name := fmt.Sprintf("consumer %s of stream %s", consumer.Name, stream.Name)
+				over := consumer.NumPending > uint64(engine.ConsumerLag)
+				if !over {
+					cl.overSince = time.Time{}
+				} else if cl.overSince.IsZero() {
+					cl.overSince = now
+				}
+				switch {
+				case over:
+					alerts = append(alerts, &Alert{
+						Condition: "consumer_lag",
+						Target:    target,
+						Message:   fmt.Sprintf("%s has %d messages pending", name, consumer.NumPending),
+						Since:     cl.overSince,
+					})
+				case cl.growth >= ConsumerLagSamples:
+					alerts = append(alerts, &Alert{
+						Condition: "consumer_lag",
+						Target:    target,
+						Message: fmt.Sprintf("%s lag grew to %d messages over %d polls",
+							name, consumer.NumPending, cl.growth),
+						Since: cl.since,
+					})
+				}
+			}
+		}
+	}
+
+	// Consumers that are gone no longer need to be tracked
+	engine.consumerLag = tracked
+
+	return alerts
+}
+
+// alertsOnConsumers reports whether the consumers are polled
+// from /jsz for alerting on them.
+func (engine *Engine) alertsOnConsumers() bool {
+	return engine.ConsumerLag > 0 || engine.AckPending > 0
+}
+
+// consumerAcks tracks the growth of the redeliveries of a consumer.
+type consumerAcks struct {
+	last      int
+	growth    int
+	since     time.Time
+	overSince time.Time
+}
+
+// checkConsumerAcks alerts in case a JetStream consumer has reached the
+// threshold percentage of its limit of acknowledgements pending, or its
+// redeliveries have been growing persistently, since either usually
+// precedes the server stalling deliveries to it. Both are disabled
+// unless the threshold is set.
+func (engine *Engine) checkConsumerAcks(jsz *Jsz, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	if engine.AckPending <= 0 || jsz == nil {
+		engine.consumerAcks = nil
+		return alerts
+	}
+
+	tracked := make(map[string]*consumerAcks)
+	for _, acc := range jsz.AccountDetails {
+		for _, stream := range acc.Streams {
+			for _, consumer := range stream.Consumers {
+				target := fmt.Sprintf("%s/%s/%s", acc.Name, stream.Name, consumer.Name)
+				ca, ok := engine.consumerAcks[target]
+				if !ok {
+					ca = &consumerAcks{last: consumer.NumRedelivered}
+				}
+
+				if consumer.NumRedelivered > ca.last {
+					if ca.growth == 0 {
+						ca.since = now
+					}
+					ca.growth++
+				} else {
+					ca.growth = 0
+				}
+				ca.last = consumer.NumRedelivered
+				tracked[target] = ca
+
+				name := fmt.Sprintf("consumer %s of stream %s", consumer.Name, stream.Name)
+				pct := consumer.AckPendingPct()
+				over := pct > 0 && pct >= engine.AckPending
+				if !over {
+					ca.overSince = time.Time{}
+				} else if ca.overSince.IsZero() {
+					ca.overSince = now
+				}
+				switch {
+				case over:
+					alerts = append(alerts, &Alert{
+						Condition: "consumer_acks",
+						Target:    target,
+						Message: fmt.Sprintf("%s has %d of %d acks pending (%.0f%%)",
+							name, consumer.NumAckPending, consumer.Config.MaxAckPending, pct),
+						Since: ca.overSince,
+					})
+				case ca.growth >= ConsumerRedeliveredSamples:
+					alerts = append(alerts, &Alert{
+						Condition: "consumer_acks",
+						Target:    target,
+						Message: fmt.Sprintf("%s redeliveries grew to %d over %d polls",
+							name, consumer.NumRedelivered, ca.growth),
+						Since: ca.since,
+					})
+				}
+			}
+		}
+	}
+
+	// Consumers that are gone no longer need to be tracked
+	engine.consumerAcks = tracked
+
+	return alerts
+}
+
+// subnet returns the /24 subnet of an IPv4 address or
+// the /64 subnet of an IPv6 address.
+func subnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		n := net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+		return n.String()
+	}
+	n := net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return n.String()
+}
+
+// topCounts returns the n keys with the highest counts
+// along with them, e.g. "go 1.9.0: 120, java 2.1.0: 30".
+func topCounts(counts map[string]int, n int) string {
+	byCount := &byCount{counts: counts}
+	for key := range counts {
+		byCount.keys = append(byCount.keys, key)
+	}
+	sort.Sort(byCount)
+
+	var top []string
+	for i, key := range byCount.keys {
+		if i == n {
+			break
+		}
+		name := key
+		if name == "" {
+			name = "unknown"
+		}
+		top = append(top, fmt.Sprintf("%s: %d", name, counts[key]))
+	}
+	return strings.Join(top, ", ")
+}
+
+// byCount sorts keys by their count, then by name.
+type byCount struct {
+	keys   []string
+	counts map[string]int
+}
+
+func (c *byCount) Len() int      { return len(c.keys) }
+func (c *byCount) Swap(i, j int) { c.keys[i], c.keys[j] = c.keys[j], c.keys[i] }
+func (c *byCount) Less(i, j int) bool {
+	if c.counts[c.keys[i]] != c.counts[c.keys[j]] {
+		return c.counts[c.keys[i]] > c.counts[c.keys[j]]
+	}
+	return c.keys[i] < c.keys[j]
+}
+
+// checkUptime alerts in case the uptime of the server decreased since
+// the previous poll, or is below the minimum uptime when set, so that
+// restarts are noticed even when not watching at the time they happened.
+func (engine *Engine) checkUptime(varz *gnatsd.Varz, now time.Time) []*Alert {
+	var alerts []*Alert
+
+	if varz.Start.IsZero() || varz.Now.IsZero() {
+		return alerts
+	}
+	uptime := varz.Now.Sub(varz.Start)
+
+	if engine.lastUptime > 0 && uptime < engine.lastUptime {
+		engine.restarted = true
+	}
+	engine.lastUptime = uptime
+
+	window := RestartWindow
+	if engine.MinUptime > window {
+		window = engine.MinUptime
+	}
+	if uptime >= window {
+		engine.restarted = false
+	}
+
+	var message string
+	switch {
+	case engine.restarted:
+		message = fmt.Sprintf("server restarted %s ago", uptime/time.Second*time.Second)
+	case engine.MinUptime > 0 && uptime < engine.MinUptime:
+		message = fmt.Sprintf("server uptime %s below %s", uptime/time.Second*time.Second, engine.MinUptime)
+	default:
+		return alerts
+	}
+	alerts = append(alerts, &Alert{
+		Condition: "server_restart",
+		Message:   message,
+		Since:     now.Add(-uptime),
+	})
+
+	return alerts
+}
+
+// alertEvents compares the alerts firing in the last poll against
+// the previous one and returns the alerts which started or resolved.
+func (engine *Engine) alertEvents(alerts []*Alert, now time.Time) []*AlertEvent {
+	var events []*AlertEvent
+
+	firing := make(map[string]*Alert)
+	for _, alert := range alerts {
+		key := alert.Condition + "/" + alert.Target
+		firing[key] = alert
+		if _, ok := engine.firing[key]; !ok {
+			events = append(events, &AlertEvent{Time: now, Alert: alert})
+		}
+	}
+
+	var resolved []string
+	for key := range engine.firing {
+		if _, ok := firing[key]; !ok {
+			resolved = append(resolved, key)
+		}
+	}
+	sort.Strings(resolved)
+	for _, key := range resolved {
+		events = append(events, &AlertEvent{Time: now, Alert: engine.firing[key], Resolved: true})
+	}
+	engine.firing = firing
+
+	return events
+}
+
+// alertRecord is an alert event as written to the alert log.
+type alertRecord struct {
+	Time      time.Time `json:"timestamp"`
+	Server    string    `json:"server"`
+	State     string    `json:"state"`
+	Condition string    `json:"condition"`
+	Target    string    `json:"target,omitempty"`
+	Message   string    `json:"message"`
+	Since     time.Time `json:"since"`
+}
+
+// writeAlertEvents writes each alert event as a line of JSON,
+// so that the alerts of a session can be processed afterwards.
+func writeAlertEvents(w io.Writer, server string, events []*AlertEvent) error {
+	enc := json.NewEncoder(w)
+	for _, event := range events {
+		state := "firing"
+		if event.Resolved {
+			state = "resolved"
+		}
+		err := enc.Encode(&alertRecord{
+			Time:      event.Time,
+			Server:    server,
+			State:     state,
+			Condition: event.Alert.Condition,
+			Target:    event.Alert.Target,
+			Message:   event.Alert.Message,
+			Since:     event.Alert.Since,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
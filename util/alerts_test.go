@@ -0,0 +1,484 @@
+package toputils
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+func TestRoutePendingAlert(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	routez := func(pending int) *server.Routez {
+		return &server.Routez{
+			NumRoutes: 1,
+			Routes:    []*server.RouteInfo{{Rid: 1, IP: "127.0.0.1", Port: 6222, Pending: pending}},
+		}
+	}
+
+	now := time.Now()
+	for i, pending := range []int{0, 100, 200} {
+		alerts := engine.checkRoutes(routez(pending), now.Add(time.Duration(i)*time.Second))
+		if len(alerts) > 0 {
+			t.Fatalf("Expected no alerts before pending grows for %d polls, got: %v", RoutePendingSamples, alerts[0].Message)
+		}
+	}
+
+	alerts := engine.checkRoutes(routez(300), now.Add(3*time.Second))
+	if len(alerts) != 1 {
+		t.Fatalf("Expected route pending alert. got: %d alerts", len(alerts))
+	}
+	if alerts[0].Condition != "route_pending" {
+		t.Fatalf("Wrong alert condition. expected: %v, got: %v", "route_pending", alerts[0].Condition)
+	}
+
+	// Draining the pending bytes resolves the alert
+	alerts = engine.checkRoutes(routez(10), now.Add(4*time.Second))
+	if len(alerts) > 0 {
+		t.Fatalf("Expected alert to be resolved once pending decreases, got: %v", alerts[0].Message)
+	}
+}
+
+func TestRouteCountAlerts(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.ClusterSize = 3
+
+	routez := func(rids ...uint64) *server.Routez {
+		rz := &server.Routez{}
+		for _, rid := range rids {
+			rz.Routes = append(rz.Routes, &server.RouteInfo{Rid: rid})
+		}
+		rz.NumRoutes = len(rz.Routes)
+		return rz
+	}
+
+	now := time.Now()
+	alerts := engine.checkRouteCount(routez(1, 2), now)
+	if len(alerts) > 0 {
+		t.Fatalf("Expected no alerts with all routes present, got: %v", alerts[0].Message)
+	}
+
+	alerts = engine.checkRouteCount(routez(1), now.Add(time.Second))
+	if len(alerts) != 1 || alerts[0].Condition != "route_missing" {
+		t.Fatalf("Expected route missing alert, got: %v", alerts)
+	}
+
+	alerts = engine.checkRouteCount(routez(1, 3), now.Add(2*time.Second))
+	if len(alerts) > 0 {
+		t.Fatalf("Expected no alerts once route is back, got: %v", alerts[0].Message)
+	}
+
+	// Third change within a minute is flapping
+	alerts = engine.checkRouteCount(routez(1, 4), now.Add(3*time.Second))
+	if len(alerts) != 1 || alerts[0].Condition != "route_flapping" {
+		t.Fatalf("Expected route flapping alert, got: %v", alerts)
+	}
+}
+
+func TestJetStreamUsageAlerts(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.JetStreamThreshold = 90
+
+	jsz := &Jsz{
+		Config: &JetStreamConfig{MaxMemory: 1000, MaxStore: 1000},
+		Memory: 500,
+		Store:  950,
+	}
+
+	now := time.Now()
+	alerts := engine.checkJetStream(jsz, now)
+	if len(alerts) != 1 || alerts[0].Condition != "js_storage" {
+		t.Fatalf("Expected JetStream storage alert, got: %v", alerts)
+	}
+
+	jsz.Memory = 900
+	alerts = engine.checkJetStream(jsz, now.Add(time.Second))
+	if len(alerts) != 2 {
+		t.Fatalf("Expected JetStream memory and storage alerts, got: %d alerts", len(alerts))
+	}
+	if !alerts[1].Since.Equal(now) {
+		t.Fatalf("Expected storage alert to keep its start time. expected: %v, got: %v", now, alerts[1].Since)
+	}
+
+	jsz.Disabled = true
+	alerts = engine.checkJetStream(jsz, now.Add(2*time.Second))
+	if len(alerts) > 0 {
+		t.Fatalf("Expected no alerts with JetStream disabled, got: %v", alerts[0].Message)
+	}
+}
+
+func TestJetStreamViewWithoutThreshold(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jsz" {
+			w.Write([]byte(`{"config":{"max_memory":1000,"max_storage":1000},"memory":950,"storage":950}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+	engine.Uri = ts.URL
+	engine.ListJetStream = true
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	select {
+	case stats := <-engine.StatsCh:
+		if stats.Jsz == nil {
+			t.Fatalf("Expected /jsz to be polled for the JetStream view, got: %s", stats.Error)
+		}
+		for _, alert := range stats.Alerts {
+			if strings.HasPrefix(alert.Condition, "js_") {
+				t.Fatalf("Expected no JetStream alerts without a threshold, got: %s", alert.Message)
+			}
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out polling the server")
+	}
+}
+
+func TestAlertEvents(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	now := time.Now()
+	pending := &Alert{Condition: "route_pending", Target: "1"}
+	missing := &Alert{Condition: "route_missing"}
+
+	events := engine.alertEvents([]*Alert{pending}, now)
+	if len(events) != 1 || events[0].Alert != pending || events[0].Resolved {
+		t.Fatalf("Expected alert to start firing, got: %+v", events)
+	}
+
+	// Alerts which keep firing do not produce new events
+	events = engine.alertEvents([]*Alert{pending, missing}, now)
+	if len(events) != 1 || events[0].Alert != missing || events[0].Resolved {
+		t.Fatalf("Expected only new alert to start firing, got: %+v", events)
+	}
+
+	events = engine.alertEvents([]*Alert{missing}, now)
+	if len(events) != 1 || events[0].Alert != pending || !events[0].Resolved {
+		t.Fatalf("Expected alert to be resolved, got: %+v", events)
+	}
+}
+
+func TestCheckUptime(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	now := time.Now()
+	start := now.Add(-time.Hour)
+	varz := &server.Varz{Start: start, Now: now}
+	if alerts := engine.checkUptime(varz, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+
+	// Uptime decreasing means the server restarted
+	now = now.Add(time.Minute)
+	varz = &server.Varz{Start: now.Add(-30 * time.Second), Now: now}
+	alerts := engine.checkUptime(varz, now)
+	if len(alerts) != 1 || alerts[0].Condition != "server_restart" {
+		t.Fatalf("Expected restart alert, got: %v", alerts)
+	}
+	if !alerts[0].Since.Equal(varz.Start) {
+		t.Fatalf("Expected alert since the restart. expected: %v, got: %v", varz.Start, alerts[0].Since)
+	}
+
+	// Alerting stops once the restart is old enough
+	now = now.Add(RestartWindow)
+	varz.Now = now
+	if alerts := engine.checkUptime(varz, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+
+	// Uptime below the minimum fires without noticing a restart
+	engine = NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.MinUptime = 10 * time.Minute
+	varz = &server.Varz{Start: now.Add(-time.Minute), Now: now}
+	alerts = engine.checkUptime(varz, now)
+	if len(alerts) != 1 || alerts[0].Message != "server uptime 1m0s below 10m0s" {
+		t.Fatalf("Expected uptime alert, got: %v", alerts)
+	}
+}
+
+func TestCheckPollDuration(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 2)
+
+	now := time.Now()
+	if alerts := engine.checkPollDuration(1500*time.Millisecond, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+
+	alerts := engine.checkPollDuration(2345678*time.Microsecond, now)
+	if len(alerts) != 1 || alerts[0].Message != "polling took 2.345s, longer than the 2s refresh interval" {
+		t.Fatalf("Expected slow poll alert, got: %v", alerts)
+	}
+
+	// Keeps firing since the first slow poll
+	later := now.Add(5 * time.Second)
+	alerts = engine.checkPollDuration(3*time.Second, later)
+	if len(alerts) != 1 || !alerts[0].Since.Equal(now) {
+		t.Fatalf("Expected slow poll alert since %v, got: %v", now, alerts)
+	}
+}
+
+func TestCheckConnStorm(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.StormConns = 3
+
+	now := time.Now()
+	connz := &server.Connz{Now: now}
+	if alerts := engine.checkConnStorm(connz, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+
+	started := now.Add(500 * time.Millisecond)
+	now = now.Add(time.Second)
+	connz = &server.Connz{Now: now, Conns: []server.ConnInfo{
+		{IP: "10.0.1.5", Lang: "go", Version: "1.2.2", Start: started},
+		{IP: "10.0.1.6", Lang: "go", Version: "1.2.2", Start: started},
+		{IP: "10.0.2.7", Lang: "java", Version: "2.1.0", Start: started},
+		{IP: "10.0.3.8", Lang: "go", Version: "1.2.2", Start: now.Add(-time.Hour)},
+	}}
+	alerts := engine.checkConnStorm(connz, now)
+	expected := "reconnect storm: 3 connections started in 1s (clients: go 1.2.2: 2, java 2.1.0: 1; subnets: 10.0.1.0/24: 2, 10.0.2.0/24: 1)"
+	if len(alerts) != 1 || alerts[0].Message != expected {
+		t.Fatalf("Expected storm alert %q, got: %v", expected, alerts)
+	}
+
+	// Keeps alerting for a while after the storm
+	now = now.Add(StormWindow / 2)
+	connz = &server.Connz{Now: now}
+	if alerts := engine.checkConnStorm(connz, now); len(alerts) != 1 {
+		t.Fatalf("Expected storm alert to stay, got: %v", alerts)
+	}
+	now = now.Add(StormWindow)
+	connz = &server.Connz{Now: now}
+	if alerts := engine.checkConnStorm(connz, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+}
+
+func TestWriteAlertEvents(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	alert := &Alert{Condition: "route_pending", Target: "1", Message: "route 1 pending grew", Since: now.Add(-time.Minute)}
+	events := []*AlertEvent{
+		{Time: now, Alert: alert},
+		{Time: now.Add(time.Minute), Alert: alert, Resolved: true},
+	}
+
+	var buf bytes.Buffer
+	if err := writeAlertEvents(&buf, "http://127.0.0.1:8222", events); err != nil {
+		t.Fatalf("Unexpected error writing alert events: %s", err)
+	}
+	expected := `{"timestamp":"2026-10-15T12:00:00Z","server":"http://127.0.0.1:8222","state":"firing","condition":"route_pending","target":"1","message":"route 1 pending grew","since":"2026-10-15T11:59:00Z"}
+{"timestamp":"2026-10-15T12:01:00Z","server":"http://127.0.0.1:8222","state":"resolved","condition":"route_pending","target":"1","message":"route 1 pending grew","since":"2026-10-15T11:59:00Z"}
+`
+	if buf.String() != expected {
+		t.Fatalf("Wrong alert events. expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestCheckConnSubs(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.ConnSubs = 500
+
+	now := time.Now()
+	first := now
+	for i := 0; i <= ConnSubsSamples; i++ {
+		connz := &server.Connz{Conns: []server.ConnInfo{
+			{Cid: 1, Name: "leaky", NumSubs: uint32(10 * (i + 1))},
+			{Cid: 2, NumSubs: 600},
+			{Cid: 3, NumSubs: 5},
+		}}
+		alerts := engine.checkConnSubs(connz, now)
+		if i < ConnSubsSamples {
+			if len(alerts) != 1 || alerts[0].Message != "connection 2 has 600 subscriptions" || !alerts[0].Since.Equal(first) {
+				t.Fatalf("Expected subscriptions alert for connection 2 since %v, got: %v", first, alerts)
+			}
+		} else {
+			expected := fmt.Sprintf("connection 1 (leaky) subscriptions grew to 110 over %d polls", ConnSubsSamples)
+			if len(alerts) != 2 || alerts[0].Message != expected || !alerts[0].Since.Equal(first.Add(time.Second)) {
+				t.Fatalf("Expected subscriptions growth alert %q, got: %v", expected, alerts)
+			}
+		}
+		now = now.Add(time.Second)
+	}
+
+	// Growth starts over once subscriptions drop
+	connz := &server.Connz{Conns: []server.ConnInfo{{Cid: 1, NumSubs: 50}}}
+	if alerts := engine.checkConnSubs(connz, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+	if len(engine.connSubs) != 1 {
+		t.Fatalf("Expected connections gone to no longer be tracked, got: %d", len(engine.connSubs))
+	}
+
+	// Subscriptions at the threshold do not exceed it
+	connz = &server.Connz{Conns: []server.ConnInfo{{Cid: 4, NumSubs: 500}}}
+	if alerts := engine.checkConnSubs(connz, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts at the threshold, got: %v", alerts[0].Message)
+	}
+
+	// Growth starts over as well in polls where subscriptions hold
+	for i := 0; i <= ConnSubsSamples+1; i++ {
+		subs := uint32(100 + i)
+		if i == ConnSubsSamples/2 {
+			subs--
+		}
+		connz = &server.Connz{Conns: []server.ConnInfo{{Cid: 5, NumSubs: subs}}}
+		if alerts := engine.checkConnSubs(connz, now); len(alerts) > 0 {
+			t.Fatalf("Expected no alerts without consecutive growth, got: %v", alerts[0].Message)
+		}
+	}
+
+	// Neither alert fires when disabled
+	engine.ConnSubs = 0
+	for i := 0; i <= ConnSubsSamples; i++ {
+		connz = &server.Connz{Conns: []server.ConnInfo{{Cid: 1, NumSubs: uint32(600 + i)}}}
+		if alerts := engine.checkConnSubs(connz, now); len(alerts) > 0 {
+			t.Fatalf("Expected no alerts when disabled, got: %v", alerts[0].Message)
+		}
+	}
+}
+
+func TestCheckConsumerLag(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.ConsumerLag = 1000
+
+	jsz := func(pending ...uint64) *Jsz {
+		stream := &StreamDetail{Name: "ORDERS"}
+		for i, p := range pending {
+			stream.Consumers = append(stream.Consumers, &ConsumerInfo{Name: fmt.Sprintf("c%d", i+1), NumPending: p})
+		}
+		return &Jsz{AccountDetails: []*JSAccountDetail{{Name: "ACME", Streams: []*StreamDetail{stream}}}}
+	}
+
+	now := time.Now()
+	first := now
+	for i := 0; i <= ConsumerLagSamples; i++ {
+		alerts := engine.checkConsumerLag(jsz(uint64(10*(i+1)), 5000, 1000), now)
+		if i < ConsumerLagSamples {
+			if len(alerts) != 1 || alerts[0].Message != "consumer c2 of stream ORDERS has 5000 messages pending" || !alerts[0].Since.Equal(first) {
+				t.Fatalf("Expected lag alert for consumer c2 since %v, got: %v", first, alerts)
+			}
+		} else {
+			expected := fmt.Sprintf("consumer c1 of stream ORDERS lag grew to 110 messages over %d polls", ConsumerLagSamples)
+			if len(alerts) != 2 || alerts[0].Message != expected || alerts[0].Target != "ACME/ORDERS/c1" || !alerts[0].Since.Equal(first.Add(time.Second)) {
+				t.Fatalf("Expected lag growth alert %q, got: %v", expected, alerts)
+			}
+		}
+		now = now.Add(time.Second)
+	}
+
+	// Growth starts over once the lag holds or drops
+	if alerts := engine.checkConsumerLag(jsz(110), now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+	if len(engine.consumerLag) != 1 {
+		t.Fatalf("Expected consumers gone to no longer be tracked, got: %d", len(engine.consumerLag))
+	}
+
+	// Neither alert fires when disabled
+	engine.ConsumerLag = 0
+	for i := 0; i <= ConsumerLagSamples; i++ {
+		if alerts := engine.checkConsumerLag(jsz(uint64(2000+i)), now); len(alerts) > 0 {
+			t.Fatalf("Expected no alerts when disabled, got: %v", alerts[0].Message)
+		}
+	}
+}
+
+func TestCheckConsumerAcks(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.AckPending = 90
+
+	jsz := func(redelivered int, ackPending ...int) *Jsz {
+		stream := &StreamDetail{Name: "ORDERS"}
+		for i, p := range ackPending {
+			stream.Consumers = append(stream.Consumers, &ConsumerInfo{Name: fmt.Sprintf("c%d", i+1),
+				NumAckPending: p, Config: &ConsumerConfig{MaxAckPending: 1000}})
+		}
+		stream.Consumers[0].NumRedelivered = redelivered
+		return &Jsz{AccountDetails: []*JSAccountDetail{{Name: "ACME", Streams: []*StreamDetail{stream}}}}
+	}
+
+	now := time.Now()
+	first := now
+	for i := 0; i <= ConsumerRedeliveredSamples; i++ {
+		alerts := engine.checkConsumerAcks(jsz(i+1, 10, 950), now)
+		if i < ConsumerRedeliveredSamples {
+			if len(alerts) != 1 || alerts[0].Message != "consumer c2 of stream ORDERS has 950 of 1000 acks pending (95%)" || !alerts[0].Since.Equal(first) {
+				t.Fatalf("Expected acks pending alert for consumer c2 since %v, got: %v", first, alerts)
+			}
+		} else {
+			expected := fmt.Sprintf("consumer c1 of stream ORDERS redeliveries grew to 11 over %d polls", ConsumerRedeliveredSamples)
+			if len(alerts) != 2 || alerts[0].Message != expected || alerts[0].Target != "ACME/ORDERS/c1" || !alerts[0].Since.Equal(first.Add(time.Second)) {
+				t.Fatalf("Expected redeliveries alert %q, got: %v", expected, alerts)
+			}
+		}
+		now = now.Add(time.Second)
+	}
+
+	// Growth starts over once the redeliveries hold, and consumers
+	// without a limit of acks pending are never over it
+	consumers := jsz(11, 10)
+	consumers.AccountDetails[0].Streams[0].Consumers[0].Config = nil
+	if alerts := engine.checkConsumerAcks(consumers, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+	if len(engine.consumerAcks) != 1 {
+		t.Fatalf("Expected consumers gone to no longer be tracked, got: %d", len(engine.consumerAcks))
+	}
+
+	// Neither alert fires when disabled
+	engine.AckPending = 0
+	for i := 0; i <= ConsumerRedeliveredSamples; i++ {
+		if alerts := engine.checkConsumerAcks(jsz(20+i, 1000), now); len(alerts) > 0 {
+			t.Fatalf("Expected no alerts when disabled, got: %v", alerts[0].Message)
+		}
+	}
+}
+
+func TestCheckRouteSubs(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	routez := &server.Routez{Routes: []*server.RouteInfo{
+		{Rid: 1, IP: "10.0.0.2", Port: 6222, NumSubs: 100},
+		{Rid: 2, IP: "10.0.0.3", Port: 6222, NumSubs: 100},
+	}}
+	now := time.Now()
+	routes := engine.countRouteSubs(routez)
+	if len(routes) != 2 || routes[0].Delta != 0 || routes[0].Remote != "10.0.0.2:6222" {
+		t.Fatalf("Wrong route subscriptions, got: %+v", routes[0])
+	}
+	if alerts := engine.checkRouteSubs(routes, now); len(alerts) > 0 {
+		t.Fatalf("Expected no alerts, got: %v", alerts[0].Message)
+	}
+
+	routez.Routes[0].NumSubs = 1500
+	routez.Routes[1].NumSubs = 150
+	routes = engine.countRouteSubs(routez)
+	if routes[0].Delta != 1400 || routes[1].Delta != 50 {
+		t.Fatalf("Wrong change of route subscriptions, got: %d and %d", routes[0].Delta, routes[1].Delta)
+	}
+	alerts := engine.checkRouteSubs(routes, now.Add(time.Second))
+	if len(alerts) != 1 || alerts[0].Target != "1" {
+		t.Fatalf("Expected alert for route 1, got: %v", alerts)
+	}
+
+	// Alert stays for a while after the jump
+	routes = engine.countRouteSubs(routez)
+	if alerts := engine.checkRouteSubs(routes, now.Add(30*time.Second)); len(alerts) != 1 {
+		t.Fatalf("Expected alert to keep firing, got: %d alerts", len(alerts))
+	}
+	if alerts := engine.checkRouteSubs(routes, now.Add(2*RouteSubsJumpWindow)); len(alerts) > 0 {
+		t.Fatalf("Expected alert to be resolved, got: %v", alerts[0].Message)
+	}
+}
@@ -0,0 +1,321 @@
+package toputils
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// Formats which can be used to display the value of a column.
+const (
+	RawFormat      = "raw"
+	PsizeFormat    = "psize"
+	DurationFormat = "duration"
+	GroupedFormat  = "grouped"
+)
+
+// HostField is the column field for the host of a connection,
+// which is displayed the same as in the default HOST column.
+const HostField = "host"
+
+// Column defines a column of the connections table, with the field
+// being the name of the connection field as reported in /connz.
+type Column struct {
+	Field  string
+	Header string
+	Width  int
+	Format string
+
+	// Colors of the cells whose values match the rules,
+	// with the last matching rule taking precedence.
+	Colors []ColorRule
+}
+
+// Operators for comparing the values of the cells in color rules.
+const (
+	AboveRule  = "above"
+	BelowRule  = "below"
+	EqualsRule = "equals"
+)
+
+// Colors which can be used in color rules.
+var Colors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ColorRule colors the cells of a column whose value is above or below
+// a threshold, or equal to a value. Values are either numbers or strings,
+// with strings like versions being compared by their numeric parts.
+type ColorRule struct {
+	Op    string
+	Value interface{}
+	Color string
+}
+
+// Color returns the color of the last rule matched by a value,
+// or an empty string when none does.
+func (col *Column) Color(v interface{}) string {
+	var color string
+	for _, rule := range col.Colors {
+		if rule.Matches(v) {
+			color = rule.Color
+		}
+	}
+	return color
+}
+
+// Matches reports whether a value matches the rule.
+func (rule *ColorRule) Matches(v interface{}) bool {
+	var cmp int
+	switch threshold := rule.Value.(type) {
+	case float64:
+		n, ok := toFloat(v)
+		if !ok {
+			return false
+		}
+		switch {
+		case n < threshold:
+			cmp = -1
+		case n > threshold:
+			cmp = 1
+		}
+	case string:
+		cmp = compareVersions(FormatValue(v, RawFormat), threshold)
+	default:
+		return false
+	}
+
+	switch rule.Op {
+	case AboveRule:
+		return cmp > 0
+	case BelowRule:
+		return cmp < 0
+	case EqualsRule:
+		return cmp == 0
+	}
+	return false
+}
+
+// toFloat returns the value of a numeric field as a float.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// compareVersions compares two strings by their dot separated parts,
+// comparing the parts numerically when both are numbers.
+func compareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+	ap, bp := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(ap) && i < len(bp); i++ {
+		an, aerr := strconv.Atoi(ap[i])
+		bn, berr := strconv.Atoi(bp[i])
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aerr != nil || berr != nil) && ap[i] != bp[i]:
+			if ap[i] < bp[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(ap) < len(bp):
+		return -1
+	case len(ap) > len(bp):
+		return 1
+	}
+	return 0
+}
+
+// connFields maps the json names of the connection fields
+// to their index in either gnatsd.ConnInfo or ExtConnInfo.
+var connFields, extConnFields = jsonFields(gnatsd.ConnInfo{}), jsonFields(ExtConnInfo{})
+
+func jsonFields(v interface{}) map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+// IsConnField reports whether a connection has a field with the name.
+func IsConnField(name string) bool {
+	_, ok := connFields[name]
+	if !ok {
+		_, ok = extConnFields[name]
+	}
+	return ok
+}
+
+// ConnField returns the value of a connection field by its name.
+func ConnField(conn *gnatsd.ConnInfo, ext *ExtConnInfo, name string) (interface{}, bool) {
+	if i, ok := connFields[name]; ok {
+		return reflect.ValueOf(conn).Elem().Field(i).Interface(), true
+	}
+	if i, ok := extConnFields[name]; ok && ext != nil {
+		return reflect.ValueOf(ext).Elem().Field(i).Interface(), true
+	}
+	return nil, false
+}
+
+// FormatValue returns the value of a field using a format.
+func FormatValue(v interface{}, format string) string {
+	switch format {
+	case PsizeFormat:
+		switch n := v.(type) {
+		case int:
+			return Psize(int64(n))
+		case int64:
+			return Psize(n)
+		case uint32:
+			return Psize(int64(n))
+		case uint64:
+			return Psize(int64(n))
+		}
+	case GroupedFormat:
+		switch n := v.(type) {
+		case int:
+			return Group(int64(n))
+		case int64:
+			return Group(n)
+		case uint32:
+			return Group(int64(n))
+		case uint64:
+			return Group(int64(n))
+		}
+	case DurationFormat:
+		switch d := v.(type) {
+		case time.Time:
+			if d.IsZero() {
+				return ""
+			}
+			return HumanDuration(time.Since(d))
+		case string:
+			if pd, err := ParseUptime(d); err == nil {
+				return HumanDuration(pd)
+			}
+		}
+	}
+
+	if strs, ok := v.([]string); ok {
+		return strings.Join(strs, ", ")
+	}
+	return fmt.Sprint(v)
+}
+
+// Group returns a count with its digits grouped by
+// thousands separators, e.g. 1,234,567.
+func Group(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	var sign string
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+
+	var out []byte
+	for i := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			out = append(out, ',')
+		}
+		out = append(out, s[i])
+	}
+	return sign + string(out)
+}
+
+// uptimeUnits are the units of the durations reported by the
+// server, from the largest to the smallest.
+var uptimeUnits = []struct {
+	name string
+	d    time.Duration
+}{
+	{"y", 365 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+}
+
+// ParseUptime parses a duration as reported by the server, e.g. 2d3h4m5s,
+// which unlike time.ParseDuration may have days and years.
+func ParseUptime(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	} else if s == "" {
+		return 0, err
+	}
+
+	var total time.Duration
+	rest := s
+	for rest != "" {
+		i := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, _ := strconv.ParseInt(rest[:i], 10, 64)
+		rest = rest[i:]
+
+		var unit time.Duration
+		for _, u := range uptimeUnits {
+			if strings.HasPrefix(rest, u.name) {
+				unit = u.d
+				rest = rest[len(u.name):]
+				break
+			}
+		}
+		if unit == 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, nil
+}
+
+// HumanDuration returns a duration rounded to its two largest
+// units, e.g. 2h13m, 3d4h or 45s.
+func HumanDuration(d time.Duration) string {
+	if d < time.Second {
+		return "0s"
+	}
+	var out string
+	for i, u := range uptimeUnits {
+		if d < u.d {
+			continue
+		}
+		out = fmt.Sprintf("%d%s", d/u.d, u.name)
+		if i+1 < len(uptimeUnits) {
+			next := uptimeUnits[i+1]
+			if n := d % u.d / next.d; n > 0 {
+				out += fmt.Sprintf("%d%s", n, next.name)
+			}
+		}
+		break
+	}
+	return out
+}
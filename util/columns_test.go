@@ -0,0 +1,142 @@
+package toputils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+func TestConnField(t *testing.T) {
+	conn := &server.ConnInfo{Cid: 5, Pending: 2048, Lang: "go"}
+	ext := &ExtConnInfo{Account: "A"}
+
+	tests := []struct {
+		field    string
+		format   string
+		expected string
+	}{
+		{"cid", RawFormat, "5"},
+		{"pending_bytes", PsizeFormat, "2.0K"},
+		{"pending_bytes", RawFormat, "2048"},
+		{"pending_bytes", GroupedFormat, "2,048"},
+		{"lang", "", "go"},
+		{"account", RawFormat, "A"},
+	}
+	for _, test := range tests {
+		v, ok := ConnField(conn, ext, test.field)
+		if !ok {
+			t.Fatalf("Expected connection to have field %q", test.field)
+		}
+		got := FormatValue(v, test.format)
+		if got != test.expected {
+			t.Fatalf("Wrong value for %q. expected: %q, got: %q", test.field, test.expected, got)
+		}
+	}
+
+	if _, ok := ConnField(conn, ext, "unknown"); ok || IsConnField("unknown") {
+		t.Fatalf("Expected unknown field to not be found")
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	got := FormatValue("1h2m3s", DurationFormat)
+	if got != "1h2m" {
+		t.Fatalf("Wrong duration. expected: %q, got: %q", "1h2m", got)
+	}
+
+	got = FormatValue("2d3h4m5s", DurationFormat)
+	if got != "2d3h" {
+		t.Fatalf("Wrong duration with days. expected: %q, got: %q", "2d3h", got)
+	}
+
+	got = FormatValue(time.Now().Add(-90*time.Second), DurationFormat)
+	if got != "1m30s" {
+		t.Fatalf("Wrong duration since time. expected: %q, got: %q", "1m30s", got)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	for _, test := range []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{1234567, "1,234,567"},
+		{-123456, "-123,456"},
+	} {
+		if got := Group(test.n); got != test.expected {
+			t.Fatalf("Wrong grouping for %d. expected: %q, got: %q", test.n, test.expected, got)
+		}
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	for _, test := range []struct {
+		uptime   string
+		expected string
+	}{
+		{"45s", "45s"},
+		{"500ms", "0s"},
+		{"12m5s", "12m5s"},
+		{"2h13m40s", "2h13m"},
+		{"1d0h5m", "1d"},
+		{"1y2d3h4m5s", "1y2d"},
+	} {
+		d, err := ParseUptime(test.uptime)
+		if err != nil {
+			t.Fatalf("Could not parse %q: %v", test.uptime, err)
+		}
+		if got := HumanDuration(d); got != test.expected {
+			t.Fatalf("Wrong duration for %q. expected: %q, got: %q", test.uptime, test.expected, got)
+		}
+	}
+
+	for _, uptime := range []string{"", "3x", "d", "5"} {
+		if _, err := ParseUptime(uptime); err == nil {
+			t.Fatalf("Expected error parsing %q", uptime)
+		}
+	}
+}
+
+func TestColumnColor(t *testing.T) {
+	col := &Column{Field: "pending_bytes", Colors: []ColorRule{
+		{Op: AboveRule, Value: float64(1024), Color: "yellow"},
+		{Op: AboveRule, Value: float64(4096), Color: "red"},
+	}}
+	for _, test := range []struct {
+		value interface{}
+		color string
+	}{
+		{512, ""},
+		{1024, ""},
+		{2048, "yellow"},
+		{int64(8192), "red"},
+		{"8192", ""},
+	} {
+		if color := col.Color(test.value); color != test.color {
+			t.Fatalf("Wrong color for %v. expected: %q, got: %q", test.value, test.color, color)
+		}
+	}
+
+	col = &Column{Field: "version", Colors: []ColorRule{
+		{Op: BelowRule, Value: "1.10.0", Color: "red"},
+		{Op: EqualsRule, Value: "", Color: "magenta"},
+	}}
+	for _, test := range []struct {
+		value interface{}
+		color string
+	}{
+		{"1.9.2", "red"},
+		{"1.10.0", ""},
+		{"1.10.1", ""},
+		{"1.10", "red"},
+		{"", "magenta"},
+	} {
+		if color := col.Color(test.value); color != test.color {
+			t.Fatalf("Wrong color for %v. expected: %q, got: %q", test.value, test.color, color)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package toputils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nats-io/gnatsd/conf"
+)
+
+// Actions which can be bound to keys in the top view.
+const (
+	QuitAction          = "quit"
+	SortAction          = "sort"
+	LimitAction         = "limit"
+	SubscriptionsAction = "subscriptions"
+	SublistAction       = "sublist"
+	AccountsAction      = "accounts"
+	RoutesAction        = "routes"
+	UsersAction         = "users"
+	GroupAction         = "group"
+	IdleAction          = "idle"
+	RoutezAction        = "routez"
+	SubjectsAction      = "subjects"
+	GatewayzAction      = "gatewayz"
+	LeafzAction         = "leafz"
+	JetStreamAction     = "jetstream"
+	AccountzAction      = "accountz"
+	AlertsAction        = "alerts"
+	AccstatzAction      = "accstatz"
+	RTTAction           = "rtt"
+	ClusterAction       = "cluster"
+	RefreshAction       = "refresh"
+	ResetAction         = "reset"
+	MarkAction          = "mark"
+	FlashAction         = "flash"
+	TotalsAction        = "totals"
+	AgeAction           = "age"
+	DNSAction           = "dns"
+	ExportAction        = "export"
+	MacroAction         = "macro"
+	InfoAction          = "info"
+	HelpAction          = "help"
+)
+
+// DefaultKeyBindings returns the keys bound to each action
+// unless configured otherwise.
+func DefaultKeyBindings() map[rune]string {
+	return map[rune]string{
+		'q': QuitAction,
+		'o': SortAction,
+		'n': LimitAction,
+		's': SubscriptionsAction,
+		'l': SublistAction,
+		'a': AccountsAction,
+		'R': RoutesAction,
+		'u': UsersAction,
+		'g': GroupAction,
+		'i': IdleAction,
+		'C': RoutezAction,
+		'S': SubjectsAction,
+		'G': GatewayzAction,
+		'L': LeafzAction,
+		'J': JetStreamAction,
+		'A': AccountzAction,
+		'H': AlertsAction,
+		'T': AccstatzAction,
+		'p': RTTAction,
+		'c': ClusterAction,
+		'r': RefreshAction,
+		'z': ResetAction,
+		'm': MarkAction,
+		'f': FlashAction,
+		't': TotalsAction,
+		'U': AgeAction,
+		'd': DNSAction,
+		'e': ExportAction,
+		'M': MacroAction,
+		'I': InfoAction,
+		'?': HelpAction,
+		'h': HelpAction,
+	}
+}
+
+// Config represents the options which can be set via a config file.
+type Config struct {
+	Keys     map[rune]string
+	Columns  []Column
+	Clusters []*Cluster
+	Macros   map[rune][]string
+}
+
+// Cluster is a set of servers which can be monitored, along with
+// the certificates used for polling them via https and the credentials
+// of their monitoring endpoints.
+type Cluster struct {
+	Name       string
+	Servers    []string
+	Cert       string
+	Key        string
+	CACert     string
+	SkipVerify bool
+	Username   string
+	Password   string
+	Token      string
+}
+
+// FindCluster returns the cluster with the name, or nil.
+func (config *Config) FindCluster(name string) *Cluster {
+	for _, cluster := range config.Clusters {
+		if cluster.Name == name {
+			return cluster
+		}
+	}
+	return nil
+}
+
+// ProcessConfigFile parses a config file, which uses the same
+// format as the NATS server config, and returns the options set.
+func ProcessConfigFile(configFile string) (*Config, error) {
+	config := &Config{Keys: DefaultKeyBindings()}
+
+	if configFile == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %v", err)
+	}
+
+	m, err := conf.Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range m {
+		switch strings.ToLower(k) {
+		case "keys":
+			km, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("error parsing keys: expected a map of actions to keys")
+			}
+			err := parseKeyBindings(config.Keys, km)
+			if err != nil {
+				return nil, err
+			}
+		case "columns":
+			cl, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("error parsing columns: expected an array of columns")
+			}
+			config.Columns, err = parseColumns(cl)
+			if err != nil {
+				return nil, err
+			}
+		case "clusters":
+			cl, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("error parsing clusters: expected an array of clusters")
+			}
+			config.Clusters, err = parseClusters(cl)
+			if err != nil {
+				return nil, err
+			}
+		case "macros":
+			mm, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("error parsing macros: expected a map of keys to macros")
+			}
+			config.Macros, err = parseMacros(mm)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown option in config file: %s", k)
+		}
+	}
+
+	// Macros cannot shadow the keys of the actions
+	for key := range config.Macros {
+		if action, ok := config.Keys[key]; ok {
+			return nil, fmt.Errorf("error parsing macros: %q already bound to %q", key, action)
+		}
+	}
+
+	return config, nil
+}
+
+// MacroKeys are the names of the keys which can be given in macros
+// between angle brackets, e.g. <enter>, besides the characters.
+var MacroKeys = []string{"enter", "esc", "backspace", "space", "up", "down"}
+
+// ParseMacro returns the keys typed by a macro, each being either a
+// character or the name of one of the MacroKeys, e.g. "Cobytes_to<enter>"
+// lists the routes and then sorts by bytes_to.
+func ParseMacro(s string) ([]string, error) {
+	var keys []string
+	for len(s) > 0 {
+		if end := strings.IndexByte(s, '>'); s[0] == '<' && end > 1 {
+			name := strings.ToLower(s[1:end])
+			if !isMacroKey(name) {
+				return nil, fmt.Errorf("unknown key in macro: %s, use one of: <%s>", s[:end+1], strings.Join(MacroKeys, ">, <"))
+			}
+			keys = append(keys, name)
+			s = s[end+1:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		keys = append(keys, string(r))
+		s = s[size:]
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty macro")
+	}
+	return keys, nil
+}
+
+func isMacroKey(name string) bool {
+	for _, k := range MacroKeys {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMacros returns the macros from the config, each bound
+// to a single key which replays the keys of the macro.
+func parseMacros(mm map[string]interface{}) (map[rune][]string, error) {
+	macros := make(map[rune][]string)
+	for key, v := range mm {
+		if utf8.RuneCountInString(key) != 1 {
+			return nil, fmt.Errorf("error parsing macros: expected a single key, got %q", key)
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("error parsing macros: expected the keys of %q as a string", key)
+		}
+		keys, err := ParseMacro(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing macros: %v", err)
+		}
+		r, _ := utf8.DecodeRuneInString(key)
+		macros[r] = keys
+	}
+	return macros, nil
+}
+
+// parseKeyBindings binds the keys from the config to their actions,
+// replacing the default keys of the action. The keys of an action
+// are given as a string, with each character being a key.
+func parseKeyBindings(keys map[rune]string, km map[string]interface{}) error {
+	actions := make(map[string]bool)
+	for _, action := range DefaultKeyBindings() {
+		actions[action] = true
+	}
+
+	configured := make(map[rune]string)
+	for action, v := range km {
+		action = strings.ToLower(action)
+		if !actions[action] {
+			return fmt.Errorf("error parsing keys: unknown action %q", action)
+		}
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return fmt.Errorf("error parsing keys: expected keys for %q as a string", action)
+		}
+
+		for key, a := range keys {
+			if a == action {
+				delete(keys, key)
+			}
+		}
+		for _, key := range s {
+			if other, ok := configured[key]; ok && other != action {
+				return fmt.Errorf("error parsing keys: %q bound to both %q and %q", key, other, action)
+			}
+			configured[key] = action
+		}
+	}
+
+	// Keys from the config take precedence over the defaults
+	for key, action := range configured {
+		keys[key] = action
+	}
+
+	return nil
+}
+
+// parseColumns returns the columns defined in the config, each being
+// a map with the field to display and optionally its header, width
+// and format.
+func parseColumns(cl []interface{}) ([]Column, error) {
+	var columns []Column
+	for _, v := range cl {
+		cm, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("error parsing columns: expected a map for each column")
+		}
+
+		var col Column
+		for k, v := range cm {
+			var ok bool
+			switch strings.ToLower(k) {
+			case "field":
+				col.Field, ok = v.(string)
+			case "header":
+				col.Header, ok = v.(string)
+			case "width":
+				var width int64
+				width, ok = v.(int64)
+				col.Width = int(width)
+			case "format":
+				col.Format, ok = v.(string)
+			case "colors":
+				var rules []interface{}
+				rules, ok = v.([]interface{})
+				if ok {
+					var err error
+					col.Colors, err = parseColorRules(rules)
+					if err != nil {
+						return nil, err
+					}
+				}
+			default:
+				return nil, fmt.Errorf("error parsing columns: unknown option %q", k)
+			}
+			if !ok {
+				return nil, fmt.Errorf("error parsing columns: invalid value for %q", k)
+			}
+		}
+
+		if col.Field != HostField && !IsConnField(col.Field) {
+			return nil, fmt.Errorf("error parsing columns: unknown field %q", col.Field)
+		}
+		switch col.Format {
+		case "", RawFormat, PsizeFormat, DurationFormat, GroupedFormat:
+		default:
+			return nil, fmt.Errorf("error parsing columns: unknown format %q", col.Format)
+		}
+		if col.Header == "" {
+			col.Header = strings.ToUpper(col.Field)
+		}
+		if col.Width <= 0 {
+			col.Width = len(col.Header) + 2
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// parseColorRules returns the color rules of a column, each being a map
+// with the color and the value to compare with using one of the operators.
+func parseColorRules(rl []interface{}) ([]ColorRule, error) {
+	var rules []ColorRule
+	for _, v := range rl {
+		rm, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("error parsing colors: expected a map for each rule")
+		}
+
+		var rule ColorRule
+		for k, v := range rm {
+			switch k = strings.ToLower(k); k {
+			case "color":
+				rule.Color, _ = v.(string)
+			case AboveRule, BelowRule, EqualsRule:
+				if rule.Op != "" {
+					return nil, fmt.Errorf("error parsing colors: more than one of %q and %q", rule.Op, k)
+				}
+				rule.Op = k
+				switch value := v.(type) {
+				case int64:
+					rule.Value = float64(value)
+				case float64, string:
+					rule.Value = value
+				default:
+					return nil, fmt.Errorf("error parsing colors: invalid value for %q", k)
+				}
+			default:
+				return nil, fmt.Errorf("error parsing colors: unknown option %q", k)
+			}
+		}
+
+		if rule.Op == "" {
+			return nil, fmt.Errorf("error parsing colors: expected one of %q, %q or %q", AboveRule, BelowRule, EqualsRule)
+		}
+		valid := false
+		for _, color := range Colors {
+			if rule.Color == color {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("error parsing colors: unknown color %q", rule.Color)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseClusters returns the clusters defined in the config, each being
+// a map with its name, servers, and optionally the certificates and
+// credentials to use.
+func parseClusters(cl []interface{}) ([]*Cluster, error) {
+	var clusters []*Cluster
+	names := make(map[string]bool)
+	for _, v := range cl {
+		cm, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("error parsing clusters: expected a map for each cluster")
+		}
+
+		cluster := &Cluster{}
+		for k, v := range cm {
+			var ok bool
+			switch strings.ToLower(k) {
+			case "name":
+				cluster.Name, ok = v.(string)
+			case "servers":
+				switch servers := v.(type) {
+				case string:
+					cluster.Servers, ok = []string{servers}, true
+				case []interface{}:
+					ok = true
+					for _, server := range servers {
+						s, isString := server.(string)
+						if !isString {
+							ok = false
+							break
+						}
+						cluster.Servers = append(cluster.Servers, s)
+					}
+				}
+			case "cert":
+				cluster.Cert, ok = v.(string)
+			case "key":
+				cluster.Key, ok = v.(string)
+			case "cacert":
+				cluster.CACert, ok = v.(string)
+			case "insecure":
+				cluster.SkipVerify, ok = v.(bool)
+			case "user":
+				cluster.Username, ok = v.(string)
+			case "password":
+				cluster.Password, ok = v.(string)
+			case "token":
+				cluster.Token, ok = v.(string)
+			default:
+				return nil, fmt.Errorf("error parsing clusters: unknown option %q", k)
+			}
+			if !ok {
+				return nil, fmt.Errorf("error parsing clusters: invalid value for %q", k)
+			}
+		}
+
+		if cluster.Name == "" {
+			return nil, fmt.Errorf("error parsing clusters: missing name")
+		}
+		if names[cluster.Name] {
+			return nil, fmt.Errorf("error parsing clusters: duplicate cluster %q", cluster.Name)
+		}
+		names[cluster.Name] = true
+
+		if len(cluster.Servers) == 0 {
+			return nil, fmt.Errorf("error parsing clusters: no servers in cluster %q", cluster.Name)
+		}
+		for _, server := range cluster.Servers {
+			if _, _, _, err := ParseServerURL(server); err != nil {
+				return nil, fmt.Errorf("error parsing clusters: %v", err)
+			}
+		}
+		if cluster.Username != "" && cluster.Token != "" {
+			return nil, fmt.Errorf("error parsing clusters: only one of user and token can be set in cluster %q", cluster.Name)
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+// KeysFor returns the keys bound to an action.
+func KeysFor(keys map[rune]string, action string) string {
+	var bound []string
+	for key, a := range keys {
+		if a == action {
+			bound = append(bound, string(key))
+		}
+	}
+	sort.Strings(bound)
+	return strings.Join(bound, "")
+}
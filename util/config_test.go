@@ -0,0 +1,269 @@
+package toputils
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "nats-top-config")
+	if err != nil {
+		t.Fatalf("Could not create config file: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(content)
+	if err != nil {
+		t.Fatalf("Could not write config file: %v", err)
+	}
+	return f.Name()
+}
+
+func TestConfigKeyBindings(t *testing.T) {
+	configFile := writeConfigFile(t, `
+keys {
+  quit: "x"
+  help: "?H"
+}
+`)
+	defer os.Remove(configFile)
+
+	config, err := ProcessConfigFile(configFile)
+	if err != nil {
+		t.Fatalf("Expected to be able to process config file. Got: %s", err)
+	}
+
+	expected := map[string]string{
+		QuitAction: "x",
+		HelpAction: "?H",
+		SortAction: "o",
+	}
+	for action, keys := range expected {
+		got := KeysFor(config.Keys, action)
+		if got != keys {
+			t.Fatalf("Wrong keys bound to %s. expected: %q, got: %q", action, keys, got)
+		}
+	}
+
+	if _, ok := config.Keys['q']; ok {
+		t.Fatalf("Expected default key to be replaced by the one from the config")
+	}
+}
+
+func TestConfigColumns(t *testing.T) {
+	configFile := writeConfigFile(t, `
+columns [
+  {field: "host", width: 22}
+  {field: "pending_bytes", header: "PENDING", width: 10, format: "psize"}
+  {field: "uptime", format: "duration"}
+  {field: "version", colors: [{below: "1.2.0", color: "red"}, {equals: "1.2.0", color: "yellow"}]}
+]
+`)
+	defer os.Remove(configFile)
+
+	config, err := ProcessConfigFile(configFile)
+	if err != nil {
+		t.Fatalf("Expected to be able to process config file. Got: %s", err)
+	}
+
+	expected := []Column{
+		{Field: "host", Header: "HOST", Width: 22},
+		{Field: "pending_bytes", Header: "PENDING", Width: 10, Format: PsizeFormat},
+		{Field: "uptime", Header: "UPTIME", Width: 8, Format: DurationFormat},
+		{Field: "version", Header: "VERSION", Width: 9, Colors: []ColorRule{
+			{Op: BelowRule, Value: "1.2.0", Color: "red"},
+			{Op: EqualsRule, Value: "1.2.0", Color: "yellow"},
+		}},
+	}
+	if len(config.Columns) != len(expected) {
+		t.Fatalf("Wrong number of columns. expected: %d, got: %d", len(expected), len(config.Columns))
+	}
+	for i, col := range expected {
+		if !reflect.DeepEqual(config.Columns[i], col) {
+			t.Fatalf("Wrong column. expected: %+v, got: %+v", col, config.Columns[i])
+		}
+	}
+}
+
+func TestConfigInvalidColumns(t *testing.T) {
+	for _, content := range []string{
+		`columns: { field: "cid" }`,
+		`columns [ { field: "unknown" } ]`,
+		`columns [ { field: "cid", format: "hex" } ]`,
+		`columns [ { field: "cid", width: "wide" } ]`,
+		`columns [ { field: "cid", color: "red" } ]`,
+		`columns [ { field: "cid", colors: [ { color: "red" } ] } ]`,
+		`columns [ { field: "cid", colors: [ { above: 1, color: "pink" } ] } ]`,
+		`columns [ { field: "cid", colors: [ { above: 1, below: 5, color: "red" } ] } ]`,
+		`columns [ { field: "cid", colors: [ { above: true, color: "red" } ] } ]`,
+	} {
+		configFile := writeConfigFile(t, content)
+		_, err := ProcessConfigFile(configFile)
+		os.Remove(configFile)
+		if err == nil {
+			t.Fatalf("Expected error processing config: %s", content)
+		}
+	}
+}
+
+func TestConfigClusters(t *testing.T) {
+	configFile := writeConfigFile(t, `
+clusters [
+  {
+    name: "east"
+    servers: ["nats-1.east:8222", "https://nats-2.east:8222"]
+    cacert: "ca.pem"
+  }
+  {name: "west", servers: "nats.west", user: "admin", password: "s3cr3t"}
+]
+`)
+	defer os.Remove(configFile)
+
+	config, err := ProcessConfigFile(configFile)
+	if err != nil {
+		t.Fatalf("Expected to be able to process config file. Got: %s", err)
+	}
+
+	east := config.FindCluster("east")
+	if east == nil || len(east.Servers) != 2 || east.CACert != "ca.pem" {
+		t.Fatalf("Wrong cluster. got: %+v", east)
+	}
+	west := config.FindCluster("west")
+	if west == nil || len(west.Servers) != 1 || west.Servers[0] != "nats.west" || west.Username != "admin" || west.Password != "s3cr3t" {
+		t.Fatalf("Wrong cluster. got: %+v", west)
+	}
+	if config.FindCluster("north") != nil {
+		t.Fatalf("Expected unknown cluster to not be found")
+	}
+}
+
+func TestConfigInvalidClusters(t *testing.T) {
+	for _, content := range []string{
+		`clusters: { name: "east" }`,
+		`clusters [ { servers: "nats:8222" } ]`,
+		`clusters [ { name: "east" } ]`,
+		`clusters [ { name: "east", servers: "ftp://nats:8222" } ]`,
+		`clusters [ { name: "east", servers: "nats:8222" }, { name: "east", servers: "nats:8222" } ]`,
+		`clusters [ { name: "east", servers: "nats:8222", user: "admin", token: "s3cr3t" } ]`,
+		`clusters [ { name: "east", servers: "nats:8222", color: "red" } ]`,
+	} {
+		configFile := writeConfigFile(t, content)
+		_, err := ProcessConfigFile(configFile)
+		os.Remove(configFile)
+		if err == nil {
+			t.Fatalf("Expected error processing config: %s", content)
+		}
+	}
+}
+
+func TestParseServerURL(t *testing.T) {
+	tests := []struct {
+		server string
+		secure bool
+		host   string
+		port   int
+	}{
+		{"127.0.0.1:8333", false, "127.0.0.1", 8333},
+		{"nats.example.com", false, "nats.example.com", 8222},
+		{"https://nats.example.com:8443", true, "nats.example.com", 8443},
+		{"http://[::1]:8222", false, "::1", 8222},
+	}
+	for _, test := range tests {
+		secure, host, port, err := ParseServerURL(test.server)
+		if err != nil {
+			t.Fatalf("Expected to parse %q. Got: %s", test.server, err)
+		}
+		if secure != test.secure || host != test.host || port != test.port {
+			t.Fatalf("Wrong endpoint for %q. expected: %v %s %d, got: %v %s %d",
+				test.server, test.secure, test.host, test.port, secure, host, port)
+		}
+	}
+
+	for _, server := range []string{"ftp://nats", "nats:port", "http://"} {
+		if _, _, _, err := ParseServerURL(server); err == nil {
+			t.Fatalf("Expected error parsing %q", server)
+		}
+	}
+}
+
+func TestConfigInvalidKeyBindings(t *testing.T) {
+	for _, content := range []string{
+		`keys { launch: "x" }`,
+		`keys { quit: 1 }`,
+		`keys { quit: "x", sort: "x" }`,
+		`colors: true`,
+	} {
+		configFile := writeConfigFile(t, content)
+		_, err := ProcessConfigFile(configFile)
+		os.Remove(configFile)
+		if err == nil {
+			t.Fatalf("Expected error processing config: %s", content)
+		}
+	}
+}
+
+func TestConfigMacros(t *testing.T) {
+	configFile := writeConfigFile(t, `
+macros {
+  "1": "C"
+  "2": "obytes_to<enter>"
+}
+`)
+	defer os.Remove(configFile)
+
+	config, err := ProcessConfigFile(configFile)
+	if err != nil {
+		t.Fatalf("Expected to be able to process config file. Got: %s", err)
+	}
+
+	expected := map[rune][]string{
+		'1': {"C"},
+		'2': {"o", "b", "y", "t", "e", "s", "_", "t", "o", "enter"},
+	}
+	if !reflect.DeepEqual(config.Macros, expected) {
+		t.Fatalf("Wrong macros. expected: %q, got: %q", expected, config.Macros)
+	}
+}
+
+func TestParseMacro(t *testing.T) {
+	for _, tc := range []struct {
+		macro    string
+		expected []string
+	}{
+		{"J<down><Enter>", []string{"J", "down", "enter"}},
+		{"o<x", []string{"o", "<", "x"}},
+		{"<>", []string{"<", ">"}},
+		{"<launch>", nil},
+		{"", nil},
+	} {
+		got, err := ParseMacro(tc.macro)
+		if tc.expected == nil {
+			if err == nil {
+				t.Fatalf("Expected error parsing macro %q", tc.macro)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.expected) {
+			t.Fatalf("Wrong keys for macro %q. expected: %q, got: %q (%v)", tc.macro, tc.expected, got, err)
+		}
+	}
+}
+
+func TestConfigInvalidMacros(t *testing.T) {
+	for _, content := range []string{
+		`macros { "12": "C" }`,
+		`macros { "1": 2 }`,
+		`macros { "1": "<nope>" }`,
+		`macros { "q": "C" }`,
+		`macros: "C"`,
+	} {
+		configFile := writeConfigFile(t, content)
+		_, err := ProcessConfigFile(configFile)
+		os.Remove(configFile)
+		if err == nil {
+			t.Fatalf("Expected error processing config: %s", content)
+		}
+	}
+}
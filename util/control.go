@@ -0,0 +1,138 @@
+package toputils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// ControlCommands are the commands accepted by Control.
+var ControlCommands = []string{"sort", "limit", "filter", "pause", "resume", "refresh", "snapshot"}
+
+// ServeControl accepts connections on the listener, e.g. a unix socket,
+// so that scripts can drive a running nats-top. Commands are sent one
+// per line and each is answered with a line, until the listener is closed.
+func (engine *Engine) ServeControl(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go engine.serveControlConn(conn)
+	}
+}
+
+// serveControlConn runs the commands sent over a connection,
+// answering "ok", the snapshot or the error of each.
+func (engine *Engine) serveControlConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+		reply, err := engine.Control(command)
+		if err != nil {
+			reply = "error: " + err.Error()
+		}
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Control runs a command, changing the options of the engine the same
+// way as the commands of the UI, and returns the reply to it. Options
+// are changed from the next poll on, which is made right away.
+func (engine *Engine) Control(command string) (string, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", errors.New("missing command")
+	}
+	args := fields[1:]
+
+	switch fields[0] {
+	case "sort":
+		if len(args) != 1 {
+			return "", errors.New("usage: sort OPTION")
+		}
+		opt := gnatsd.SortOpt(args[0])
+		if !IsValidSortOpt(opt) {
+			return "", fmt.Errorf("invalid order: %s, use one of: %s", args[0], SortOptsList())
+		}
+		engine.SetSortOpt(opt)
+	case "limit":
+		var n int
+		if len(args) != 1 {
+			return "", errors.New("usage: limit N")
+		}
+		if _, err := fmt.Sscanf(args[0], "%d", &n); err != nil || n < 1 {
+			return "", fmt.Errorf("invalid limit: %s, must be a number of at least 1", args[0])
+		}
+		engine.SetConns(n)
+	case "filter":
+		// Filters are cleared when no name is given
+		if len(args) < 1 || len(args) > 2 {
+			return "", errors.New("usage: filter account|user [NAME]")
+		}
+		var name string
+		if len(args) == 2 {
+			name = args[1]
+		}
+		switch args[0] {
+		case "account":
+			engine.SetAccount(name)
+		case "user":
+			engine.SetUser(name)
+		default:
+			return "", fmt.Errorf("invalid filter: %s, use account or user", args[0])
+		}
+	case "pause":
+		engine.SetPaused(true)
+	case "resume":
+		engine.SetPaused(false)
+	case "refresh":
+		engine.Refresh()
+	case "snapshot":
+		return engine.snapshot()
+	default:
+		return "", fmt.Errorf("unknown command: %s, use one of: %s", fields[0], strings.Join(ControlCommands, ", "))
+	}
+
+	return "ok", nil
+}
+
+// controlSnapshot is the latest poll as answered to the snapshot command.
+type controlSnapshot struct {
+	Time   time.Time     `json:"time"`
+	Server string        `json:"server"`
+	Varz   *gnatsd.Varz  `json:"varz"`
+	Connz  *gnatsd.Connz `json:"connz"`
+	Rates  *Rates        `json:"rates"`
+	Alerts []*Alert      `json:"alerts,omitempty"`
+}
+
+// snapshot returns the stats of the latest successful poll as a line of JSON.
+func (engine *Engine) snapshot() (string, error) {
+	stats, ok := engine.last.Load().(*Stats)
+	if !ok || stats == nil {
+		return "", errors.New("no stats polled yet")
+	}
+	data, err := json.Marshal(&controlSnapshot{
+		Time:   stats.Varz.Now,
+		Server: stats.Options.Server,
+		Varz:   stats.Varz,
+		Connz:  stats.Connz,
+		Rates:  stats.Rates,
+		Alerts: stats.Alerts,
+	})
+	return string(data), err
+}
@@ -0,0 +1,182 @@
+package toputils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+func TestControl(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	for _, tc := range []struct {
+		command string
+		err     bool
+	}{
+		{"sort bytes_to", false},
+		{"sort nope", true},
+		{"limit 5", false},
+		{"limit 0", true},
+		{"filter account ACME", false},
+		{"filter user alice", false},
+		{"filter user", false},
+		{"filter nope x", true},
+		{"snapshot", true},
+		{"explode", true},
+	} {
+		reply, err := engine.Control(tc.command)
+		if tc.err != (err != nil) {
+			t.Fatalf("%q: expected error %v, got: %v", tc.command, tc.err, err)
+		}
+		if err == nil && reply != "ok" {
+			t.Fatalf("%q: expected ok, got: %q", tc.command, reply)
+		}
+	}
+
+	// Options are only changed by the goroutine polling
+	if engine.SortOpt != "" || engine.Conns != 10 || engine.Account != "" {
+		t.Fatalf("Expected options to be changed before the next poll, got: %+v", engine.Options())
+	}
+	if !engine.applyChanges() {
+		t.Fatalf("Expected filtering to measure again from scratch")
+	}
+	if engine.SortOpt != server.SortOpt("bytes_to") {
+		t.Fatalf("Expected sort by %s, got: %s", server.SortOpt("bytes_to"), engine.SortOpt)
+	}
+	if engine.Conns != 5 {
+		t.Fatalf("Expected a limit of 5 connections, got: %d", engine.Conns)
+	}
+	if engine.Account != "ACME" || engine.User != "" {
+		t.Fatalf("Expected filter on account ACME only, got: %q, %q", engine.Account, engine.User)
+	}
+}
+
+func TestControlWhilePolling(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{}")
+	}))
+	defer s.Close()
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.SetupHTTP()
+	if err := engine.SetupServers([]string{s.URL}, false); err != nil {
+		t.Fatalf("Expected to set up servers. Got: %s", err)
+	}
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+	<-engine.StatsCh
+
+	// Commands change the options of the next polls without racing them
+	for _, command := range []string{"sort bytes_to", "limit 5"} {
+		if _, err := engine.Control(command); err != nil {
+			t.Fatalf("%q: expected ok, got: %v", command, err)
+		}
+	}
+	timeout := time.After(3 * time.Second)
+	for applied := false; !applied; {
+		select {
+		case stats := <-engine.StatsCh:
+			applied = stats.Options.SortOpt == server.SortOpt("bytes_to") && stats.Options.Conns == 5
+		case <-timeout:
+			t.Fatalf("Timed out changing the options")
+		}
+	}
+}
+
+func TestServeControl(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 60)
+	engine.SetupHTTP()
+	s := runMonitorServer(server.DEFAULT_HTTP_PORT)
+	defer s.Shutdown()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer l.Close()
+	go engine.ServeControl(l)
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	engine.Refresh()
+	select {
+	case <-engine.StatsCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out polling the server")
+	}
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to the control listener: %v", err)
+	}
+	defer conn.Close()
+	replies := bufio.NewReader(conn)
+	send := func(command string) string {
+		fmt.Fprintln(conn, command)
+		reply, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%q: could not read the reply: %v", command, err)
+		}
+		return strings.TrimSpace(reply)
+	}
+
+	var snapshot struct {
+		Server string
+		Varz   *server.Varz
+	}
+	if err := json.Unmarshal([]byte(send("snapshot")), &snapshot); err != nil {
+		t.Fatalf("Could not decode the snapshot: %v", err)
+	}
+	if snapshot.Server != engine.Uri || snapshot.Varz == nil || snapshot.Varz.Cores < 1 {
+		t.Fatalf("Unexpected snapshot: %+v", snapshot)
+	}
+
+	if reply := send("limit x"); !strings.HasPrefix(reply, "error: ") {
+		t.Fatalf("Expected an error, got: %q", reply)
+	}
+
+	// Options changed while polling are those of the next poll
+	for _, command := range []string{"sort bytes_to", "limit 5", "filter account $G"} {
+		if reply := send(command); reply != "ok" {
+			t.Fatalf("%q: expected ok, got: %q", command, reply)
+		}
+	}
+	timeout := time.After(3 * time.Second)
+	for applied := false; !applied; {
+		select {
+		case stats := <-engine.StatsCh:
+			opts := stats.Options
+			applied = opts.SortOpt == server.SortOpt("bytes_to") && opts.Conns == 5 && opts.Account == "$G"
+		case <-timeout:
+			t.Fatalf("Timed out changing the options")
+		}
+	}
+
+	// No polls are made while paused, even when refreshing
+	if reply := send("pause"); reply != "ok" {
+		t.Fatalf("Expected ok, got: %q", reply)
+	}
+	send("refresh")
+	select {
+	case <-engine.StatsCh:
+		t.Fatalf("Expected no polls while paused")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	send("resume")
+	send("refresh")
+	select {
+	case <-engine.StatsCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out polling after resuming")
+	}
+}
@@ -0,0 +1,75 @@
+package toputils
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequestError is an error polling the monitoring endpoint of a server,
+// either failing to get a response or getting an unexpected one.
+type RequestError struct {
+	URI        string
+	StatusCode int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("could not get stats from server: %s responded with %d %s",
+			e.URI, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("could not get stats from server: %v", e.Err)
+}
+
+// Hint returns what to check in order to fix the error,
+// or an empty string when its cause is not known.
+func (e *RequestError) Hint() string {
+	switch e.StatusCode {
+	case 0:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "the endpoint requires credentials, check the user and password set via -u and -p, the token set via -token, or the client certificate set via -cert and -key"
+	case http.StatusNotFound:
+		return "the endpoint is not a NATS monitoring one, check the port is the monitoring port (-m or -ms)"
+	case http.StatusBadRequest:
+		return "the server may expect https, try setting the monitoring port via -ms instead of -m"
+	default:
+		return ""
+	}
+
+	err := e.Err
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if operr, ok := err.(*net.OpError); ok {
+		if _, ok := operr.Err.(*net.DNSError); ok {
+			return "could not resolve the host, check the server set via -s"
+		}
+	}
+	switch err.(type) {
+	case x509.UnknownAuthorityError:
+		return "the server certificate is not trusted, set the CA via -cacert or skip verifying it via -k"
+	case x509.HostnameError:
+		return "the server certificate is not valid for the host, check the server set via -s or skip verifying it via -k"
+	case x509.CertificateInvalidError:
+		return "the server certificate is invalid, e.g. it expired, or skip verifying it via -k"
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "certificate required"), strings.Contains(msg, "bad certificate"):
+		return "the server requires a client certificate, set it via -cert and -key"
+	case strings.Contains(msg, "connection refused"):
+		return "check the server is running with monitoring enabled on that port, e.g. via -m 8222 in the server"
+	case strings.Contains(msg, "HTTP response to HTTPS client"):
+		return "the server does not use https on that port, set the monitoring port via -m instead of -ms"
+	case strings.Contains(msg, "malformed HTTP"):
+		return "the endpoint does not speak http, check it is the monitoring port and not the client one"
+	case strings.Contains(msg, "timeout"):
+		return "the server did not respond in time, check it is reachable and not firewalled"
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package toputils
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestHealth(t *testing.T) {
+	healthy := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy {
+			w.Write([]byte(`{"status":"ok"}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"unavailable","error":"JetStream has not established contact with a meta leader"}`))
+	}))
+	defer ts.Close()
+
+	engine := &Engine{Uri: ts.URL, HttpClient: &http.Client{}}
+	if health := engine.requestHealth(); health == nil || health.Status != "ok" {
+		t.Fatalf("Expected healthy server, got: %+v", health)
+	}
+	healthy = false
+	if health := engine.requestHealth(); health == nil || health.Status != "unavailable" || !strings.Contains(health.Error, "meta leader") {
+		t.Fatalf("Expected unhealthy server along with the error, got: %+v", health)
+	}
+
+	// Servers without /healthz do not report their health
+	ts = httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	engine.Uri = ts.URL
+	if health := engine.requestHealth(); health != nil {
+		t.Fatalf("Expected no health from older servers, got: %+v", health)
+	}
+
+	// Health of servers that could not be polled is unknown
+	ts.Close()
+	if health := engine.requestHealth(); health == nil || health.Status != HealthUnknown {
+		t.Fatalf("Expected unknown health, got: %+v", health)
+	}
+}
+
+func TestRequestErrorHints(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/varz":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	engine := &Engine{Uri: ts.URL, HttpClient: &http.Client{}}
+	for _, test := range []struct {
+		path string
+		hint string
+	}{
+		{"/varz", "requires credentials"},
+		{"/connz", "not a NATS monitoring one"},
+	} {
+		_, err := engine.Request(test.path)
+		rerr, ok := err.(*RequestError)
+		if !ok {
+			t.Fatalf("Expected request error getting %s, got: %v", test.path, err)
+		}
+		if !strings.Contains(rerr.Hint(), test.hint) {
+			t.Fatalf("Wrong hint for %s. expected: %q, got: %q", test.path, test.hint, rerr.Hint())
+		}
+	}
+
+	// Errors are single lines, shown along with their hints
+	_, err := engine.Request("/varz")
+	expected := "could not get stats from server: " + ts.URL + "/varz responded with 401 Unauthorized"
+	if err.Error() != expected {
+		t.Fatalf("Wrong error. expected: %q, got: %q", expected, err.Error())
+	}
+
+	// Nothing listening after closing the server
+	ts.Close()
+	_, err = engine.Request("/varz")
+	rerr, ok := err.(*RequestError)
+	if !ok || !strings.Contains(rerr.Hint(), "monitoring enabled") {
+		t.Fatalf("Expected hint for connection refused, got: %v", err)
+	}
+
+	// Plain http servers when polling via https
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	engine.Uri = strings.Replace(ts.URL, "http://", "https://", 1)
+	_, err = engine.Request("/varz")
+	rerr, ok = err.(*RequestError)
+	if !ok || !strings.Contains(rerr.Hint(), "does not use https") {
+		t.Fatalf("Expected hint for plain http server, got: %v", err)
+	}
+}
+
+func TestStatsErrorTextBadJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>not json</html>"))
+	}))
+	defer ts.Close()
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.SetupHTTP()
+	if err := engine.SetupServers([]string{ts.URL}, false); err != nil {
+		t.Fatalf("Expected to set up servers. Got: %s", err)
+	}
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	// Errors are printed as single lines by the status and headless output
+	select {
+	case stats := <-engine.StatsCh:
+		text := stats.ErrorText()
+		if !strings.Contains(text, "could not unmarshal json") || strings.Contains(text, "\n") {
+			t.Fatalf("Expected a single line error, got: %q", text)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out polling the server")
+	}
+}
+
+func TestRequestClientCertificate(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"server_id":"test"}`))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	engine := NewEngine("127.0.0.1", 8223, 10, 1)
+	if err := engine.SetupHTTPS("", "./test/client-cert.pem", "", true); err == nil {
+		t.Fatalf("Expected error setting a client certificate without its key")
+	}
+
+	if err := engine.SetupHTTPS("", "", "", true); err != nil {
+		t.Fatalf("Expected to be able to configure polling via HTTPS. Got: %s", err)
+	}
+	engine.Uri = ts.URL
+	_, err := engine.Request("/varz")
+	rerr, ok := err.(*RequestError)
+	if !ok || !strings.Contains(rerr.Hint(), "requires a client certificate") {
+		t.Fatalf("Expected hint for missing client certificate, got: %v", err)
+	}
+
+	if err := engine.SetupHTTPS("", "./test/client-cert.pem", "./test/client-key.pem", true); err != nil {
+		t.Fatalf("Expected to be able to configure polling via HTTPS. Got: %s", err)
+	}
+	engine.Uri = ts.URL
+	for _, path := range []string{"/varz", "/connz", "/routez"} {
+		if _, err := engine.Request(path); err != nil {
+			t.Fatalf("Expected to get %s presenting the client certificate. Got: %v", path, err)
+		}
+	}
+}
+
+func TestRequestBasicAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer ts.Close()
+
+	engine := &Engine{Uri: ts.URL, HttpClient: &http.Client{}}
+	_, err := engine.Request("/varz")
+	if rerr, ok := err.(*RequestError); !ok || rerr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected unauthorized request without credentials, got: %v", err)
+	}
+
+	engine.Username, engine.Password = "admin", "s3cret"
+	if _, err := engine.Request("/varz"); err != nil {
+		t.Fatalf("Expected request with credentials to succeed, got: %v", err)
+	}
+	if health := engine.requestHealth(); health == nil || health.Status != "ok" {
+		t.Fatalf("Expected health polled with credentials, got: %+v", health)
+	}
+}
+
+func TestRequestBearerToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	engine := &Engine{Uri: ts.URL, HttpClient: &http.Client{}}
+	_, err := engine.Request("/varz")
+	if rerr, ok := err.(*RequestError); !ok || !strings.Contains(rerr.Hint(), "-token") {
+		t.Fatalf("Expected forbidden request hinting at the token, got: %v", err)
+	}
+
+	engine.Token = "t0ken"
+	if _, err := engine.Request("/varz"); err != nil {
+		t.Fatalf("Expected request with the token to succeed, got: %v", err)
+	}
+}
@@ -0,0 +1,382 @@
+package toputils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExtVarz holds the /varz fields reported by newer NATS servers
+// which are not part of the vendored gnatsd.Varz.
+type ExtVarz struct {
+	SlowConsumersStats *SlowConsumersStats `json:"slow_consumer_stats,omitempty"`
+	Leafs              int                 `json:"leafnodes"`
+	Gateway            *GatewayVarz        `json:"gateway,omitempty"`
+	Name               string              `json:"server_name,omitempty"`
+	MaxSubs            int                 `json:"max_subscriptions,omitempty"`
+	WriteDeadline      time.Duration       `json:"write_deadline,omitempty"`
+	Cluster            *ClusterVarz        `json:"cluster,omitempty"`
+	LeafNode           *LeafNodeVarz       `json:"leaf,omitempty"`
+}
+
+// ClusterVarz is the cluster config reported by newer servers.
+type ClusterVarz struct {
+	Name string   `json:"name,omitempty"`
+	Host string   `json:"addr,omitempty"`
+	Port int      `json:"cluster_port,omitempty"`
+	URLs []string `json:"urls,omitempty"`
+}
+
+// LeafNodeVarz is the leafnode config reported by newer servers.
+type LeafNodeVarz struct {
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// GatewayVarz has the gateways configured in a server.
+type GatewayVarz struct {
+	Name     string              `json:"name,omitempty"`
+	Host     string              `json:"host,omitempty"`
+	Port     int                 `json:"port,omitempty"`
+	Gateways []RemoteGatewayVarz `json:"gateways,omitempty"`
+}
+
+// RemoteGatewayVarz is a gateway to another cluster.
+type RemoteGatewayVarz struct {
+	Name string `json:"name"`
+}
+
+// SlowConsumersStats breaks down the slow consumers of a server
+// by the kind of connection that was affected.
+type SlowConsumersStats struct {
+	Clients  uint64 `json:"clients"`
+	Routes   uint64 `json:"routes"`
+	Gateways uint64 `json:"gateways"`
+	Leafs    uint64 `json:"leafs"`
+}
+
+// ExtConnz holds the /connz fields reported by newer NATS servers which
+// are not part of the vendored gnatsd.Connz. Connections are decoded
+// from the same response so they are in the same order as in Connz.
+type ExtConnz struct {
+	Conns []ExtConnInfo `json:"connections"`
+}
+
+// ExtConnInfo has the fields of a connection which are not part
+// of the vendored gnatsd.ConnInfo.
+type ExtConnInfo struct {
+	Account string `json:"account,omitempty"`
+	RTT     string `json:"rtt,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+	Type    string `json:"type,omitempty"`
+
+	// Subscriptions of the connection when requested with subs=detail
+	SubsDetail []SubDetails `json:"subscriptions_list_detail,omitempty"`
+}
+
+// ExtSubsz holds the /subsz fields reported by newer NATS servers,
+// which list the subscriptions when requested with subs=1.
+type ExtSubsz struct {
+	Total int          `json:"total"`
+	Subs  []SubDetails `json:"subscriptions_list,omitempty"`
+}
+
+// SubDetails is a subscription listed by newer servers.
+type SubDetails struct {
+	Account string `json:"account,omitempty"`
+	Subject string `json:"subject"`
+	Queue   string `json:"qgroup,omitempty"`
+	Sid     string `json:"sid,omitempty"`
+	Msgs    int64  `json:"msgs"`
+	Max     int64  `json:"max,omitempty"`
+	Cid     uint64 `json:"cid"`
+}
+
+// ConnKind returns the kind of a connection for display, using the
+// client type for the clients which are not using the NATS protocol.
+func ConnKind(conn *ExtConnInfo) string {
+	switch strings.ToLower(conn.Type) {
+	case "mqtt":
+		return "MQTT"
+	case "websocket":
+		return "WS"
+	}
+
+	switch strings.ToLower(conn.Kind) {
+	case "client":
+		return "CLIENT"
+	case "router":
+		return "ROUTER"
+	case "gateway":
+		return "GATEWAY"
+	case "leafnode":
+		return "LEAF"
+	}
+	return strings.ToUpper(conn.Kind)
+}
+
+// Jsz represents the JetStream information from /jsz.
+type Jsz struct {
+	Disabled       bool               `json:"disabled,omitempty"`
+	Config         *JetStreamConfig   `json:"config,omitempty"`
+	Memory         uint64             `json:"memory"`
+	Store          uint64             `json:"storage"`
+	ReservedMemory uint64             `json:"reserved_memory"`
+	ReservedStore  uint64             `json:"reserved_storage"`
+	Accounts       int                `json:"accounts"`
+	HAAssets       int                `json:"ha_assets"`
+	API            JetStreamAPIStats  `json:"api"`
+	Streams        int                `json:"streams"`
+	Consumers      int                `json:"consumers"`
+	Messages       uint64             `json:"messages"`
+	Bytes          uint64             `json:"bytes"`
+	AccountDetails []*JSAccountDetail `json:"account_details,omitempty"`
+}
+
+// JSAccountDetail is the JetStream usage of an account,
+// listed by /jsz when requested with accounts=true.
+type JSAccountDetail struct {
+	Name    string            `json:"name"`
+	Memory  uint64            `json:"memory"`
+	Store   uint64            `json:"storage"`
+	API     JetStreamAPIStats `json:"api"`
+	Streams []*StreamDetail   `json:"stream_detail,omitempty"`
+}
+
+// StreamDetail is a stream of an account,
+// listed by /jsz when requested with streams=true.
+type StreamDetail struct {
+	Name      string             `json:"name"`
+	Config    *StreamConfig      `json:"config,omitempty"`
+	Cluster   *StreamClusterInfo `json:"cluster,omitempty"`
+	State     StreamState        `json:"state"`
+	Consumers []*ConsumerInfo    `json:"consumer_detail,omitempty"`
+}
+
+// ConsumerInfo is a consumer of a stream,
+// listed by /jsz when requested with consumers=true.
+type ConsumerInfo struct {
+	Name           string          `json:"name"`
+	Delivered      SequenceInfo    `json:"delivered"`
+	AckFloor       SequenceInfo    `json:"ack_floor"`
+	NumAckPending  int             `json:"num_ack_pending"`
+	NumRedelivered int             `json:"num_redelivered"`
+	NumWaiting     int             `json:"num_waiting"`
+	NumPending     uint64          `json:"num_pending"`
+	Config         *ConsumerConfig `json:"config,omitempty"`
+}
+
+// ConsumerConfig has the configured limit of the acknowledgements
+// pending of a consumer, reported by /jsz when requested with config=true.
+type ConsumerConfig struct {
+	MaxAckPending int `json:"max_ack_pending"`
+}
+
+// AckPendingPct returns the acknowledgements pending as a percentage
+// of the configured limit, past which the server stops delivering to
+// the consumer, or 0 when there is no limit.
+func (c *ConsumerInfo) AckPendingPct() float64 {
+	if c.Config == nil || c.Config.MaxAckPending <= 0 {
+		return 0
+	}
+	return float64(c.NumAckPending) / float64(c.Config.MaxAckPending) * 100
+}
+
+// SequenceInfo has the last sequences delivered or acknowledged
+// by a consumer, in the consumer and in the stream.
+type SequenceInfo struct {
+	Consumer uint64 `json:"consumer_seq"`
+	Stream   uint64 `json:"stream_seq"`
+}
+
+// StreamConfig has the configured replicas of a stream,
+// reported by /jsz when requested with config=true.
+type StreamConfig struct {
+	Replicas int `json:"num_replicas"`
+}
+
+// StreamClusterInfo has the leader and replicas of a clustered stream.
+type StreamClusterInfo struct {
+	Leader   string      `json:"leader,omitempty"`
+	Replicas []*PeerInfo `json:"replicas,omitempty"`
+}
+
+// PeerInfo is a replica of a stream other than its leader.
+type PeerInfo struct {
+	Name    string `json:"name"`
+	Current bool   `json:"current"`
+	Lag     uint64 `json:"lag,omitempty"`
+}
+
+// StreamState has the messages stored in a stream.
+type StreamState struct {
+	Msgs      uint64 `json:"messages"`
+	Bytes     uint64 `json:"bytes"`
+	FirstSeq  uint64 `json:"first_seq"`
+	LastSeq   uint64 `json:"last_seq"`
+	Consumers int    `json:"consumer_count"`
+}
+
+// JetStreamConfig has the configured limits of JetStream in a server.
+type JetStreamConfig struct {
+	MaxMemory int64  `json:"max_memory"`
+	MaxStore  int64  `json:"max_storage"`
+	StoreDir  string `json:"store_dir,omitempty"`
+}
+
+// JetStreamAPIStats has the number of JetStream API requests and errors.
+type JetStreamAPIStats struct {
+	Total  uint64 `json:"total"`
+	Errors uint64 `json:"errors"`
+}
+
+// Gatewayz represents the gateways of a super-cluster from /gatewayz,
+// which is only reported by newer servers.
+type Gatewayz struct {
+	Name             string                       `json:"name"`
+	OutboundGateways map[string]*RemoteGatewayz   `json:"outbound_gateways"`
+	InboundGateways  map[string][]*RemoteGatewayz `json:"inbound_gateways"`
+}
+
+// RemoteGatewayz is a connection to or from a remote gateway.
+type RemoteGatewayz struct {
+	IsConfigured bool          `json:"configured"`
+	Connection   *GatewayConnz `json:"connection,omitempty"`
+}
+
+// GatewayConnz has the counters of a gateway connection.
+type GatewayConnz struct {
+	Cid      uint64 `json:"cid"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Pending  int    `json:"pending_bytes"`
+	InMsgs   int64  `json:"in_msgs"`
+	OutMsgs  int64  `json:"out_msgs"`
+	InBytes  int64  `json:"in_bytes"`
+	OutBytes int64  `json:"out_bytes"`
+}
+
+// Healthz is the health of a server from /healthz, which is
+// only reported by newer servers, along with why it is unhealthy.
+type Healthz struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthUnknown is the status of a server whose health
+// could not be polled while its stats could.
+const HealthUnknown = "unknown"
+
+// Leafz represents the leafnode connections from /leafz,
+// which is only reported by newer servers.
+type Leafz struct {
+	Leafs []*LeafInfo `json:"leafs"`
+}
+
+// Accountz represents the accounts of a server from /accountz, which
+// is only reported by newer servers. The detail of a single account is
+// reported instead of the list when requested with acc=name.
+type Accountz struct {
+	SystemAccount string       `json:"system_account,omitempty"`
+	Accounts      []string     `json:"accounts,omitempty"`
+	Account       *AccountInfo `json:"account_detail,omitempty"`
+}
+
+// AccountInfo is the detail of an account, along with its claims
+// when it is defined by a JWT.
+type AccountInfo struct {
+	Name      string         `json:"account_name"`
+	NameTag   string         `json:"name_tag,omitempty"`
+	IssuerKey string         `json:"issuer_key,omitempty"`
+	IsSystem  bool           `json:"is_system,omitempty"`
+	Expired   bool           `json:"expired"`
+	JetStream bool           `json:"jetstream_enabled"`
+	Clients   int            `json:"client_connections"`
+	Leafs     int            `json:"leafnode_connections"`
+	Subs      uint32         `json:"subscriptions"`
+	Imports   []*ImportInfo  `json:"imports,omitempty"`
+	Exports   []*ExportInfo  `json:"exports,omitempty"`
+	Claims    *AccountClaims `json:"decoded_jwt,omitempty"`
+}
+
+// ImportInfo is a stream or service imported from another account.
+type ImportInfo struct {
+	Subject string `json:"subject"`
+	Account string `json:"account"`
+	Type    string `json:"type"`
+	Invalid bool   `json:"invalid"`
+}
+
+// ExportInfo is a stream or service exported to other accounts,
+// or to every account when none are approved.
+type ExportInfo struct {
+	Subject  string   `json:"subject"`
+	Type     string   `json:"type"`
+	Approved []string `json:"approved_accounts,omitempty"`
+}
+
+// AccountClaims are the claims of the JWT of an account.
+type AccountClaims struct {
+	Nats struct {
+		Limits AccountLimits `json:"limits"`
+	} `json:"nats"`
+}
+
+// AccountLimits are the limits of an account, -1 meaning unlimited.
+type AccountLimits struct {
+	Conn    int64 `json:"conn"`
+	Leaf    int64 `json:"leaf"`
+	Subs    int64 `json:"subs"`
+	Data    int64 `json:"data"`
+	Payload int64 `json:"payload"`
+	Imports int64 `json:"imports"`
+	Exports int64 `json:"exports"`
+}
+
+// Accstatz represents the statistics of the accounts with connections
+// from /accstatz, which is only reported by newer servers.
+type Accstatz struct {
+	Accounts []*AccountStat `json:"account_statz"`
+}
+
+// AccountStat has the connections and counters of an account, where
+// sent are the messages delivered to its clients and received those
+// published by them.
+type AccountStat struct {
+	Account       string    `json:"acc"`
+	Conns         int       `json:"conns"`
+	LeafNodes     int       `json:"leafnodes"`
+	TotalConns    int       `json:"total_conns"`
+	NumSubs       uint32    `json:"num_subscriptions"`
+	Sent          DataStats `json:"sent"`
+	Received      DataStats `json:"received"`
+	SlowConsumers int64     `json:"slow_consumers"`
+}
+
+// DataStats are the messages and bytes sent or received.
+type DataStats struct {
+	Msgs  int64 `json:"msgs"`
+	Bytes int64 `json:"bytes"`
+}
+
+// LeafInfo has the counters of a leafnode connection, and the
+// subscriptions of its remote when requested with subs=1.
+type LeafInfo struct {
+	Name     string   `json:"name,omitempty"`
+	Account  string   `json:"account"`
+	IP       string   `json:"ip"`
+	Port     int      `json:"port"`
+	RTT      string   `json:"rtt,omitempty"`
+	InMsgs   int64    `json:"in_msgs"`
+	OutMsgs  int64    `json:"out_msgs"`
+	InBytes  int64    `json:"in_bytes"`
+	OutBytes int64    `json:"out_bytes"`
+	NumSubs  uint32   `json:"subscriptions"`
+	Subs     []string `json:"subscriptions_list,omitempty"`
+}
+
+// Addr returns the address of the remote of a leafnode connection,
+// which identifies it across polls.
+func (leaf *LeafInfo) Addr() string {
+	return fmt.Sprintf("%s:%d", leaf.IP, leaf.Port)
+}
@@ -0,0 +1,53 @@
+package toputils
+
+import (
+	"errors"
+	"strings"
+)
+
+// Sink receives the stats each time they are polled, so that they
+// can be printed or sent elsewhere than the UI. New backends only
+// need to implement it to be used along with the existing ones.
+type Sink interface {
+	// Write handles the stats of a poll, which has
+	// their Error set in case the poll failed.
+	Write(stats *Stats) error
+
+	// Close releases the resources used by the sink.
+	Close() error
+}
+
+// MultiSink fans out the stats to each of its sinks, so that more than
+// one can be used at once. A sink failing does not prevent the rest
+// from getting the stats, the errors of all of them are returned.
+type MultiSink []Sink
+
+// Write writes the stats to every sink.
+func (ms MultiSink) Write(stats *Stats) error {
+	var errs []string
+	for _, sink := range ms {
+		if err := sink.Write(stats); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return joinErrors(errs)
+}
+
+// Close closes every sink.
+func (ms MultiSink) Close() error {
+	var errs []string
+	for _, sink := range ms {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return joinErrors(errs)
+}
+
+// joinErrors returns the errors as one, or nil when there are none.
+func joinErrors(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
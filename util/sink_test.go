@@ -0,0 +1,41 @@
+package toputils
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testSink struct {
+	written int
+	closed  bool
+	err     error
+}
+
+func (s *testSink) Write(stats *Stats) error {
+	s.written++
+	return s.err
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return s.err
+}
+
+func TestMultiSink(t *testing.T) {
+	failing := &testSink{err: fmt.Errorf("connection refused")}
+	ok := &testSink{}
+	sinks := MultiSink{failing, ok}
+
+	err := sinks.Write(&Stats{})
+	if err == nil || err.Error() != "connection refused" {
+		t.Fatalf("Expected error from the failing sink, got: %v", err)
+	}
+	if failing.written != 1 || ok.written != 1 {
+		t.Fatalf("Expected stats to be written to every sink, got: %d and %d", failing.written, ok.written)
+	}
+
+	sinks.Close()
+	if !failing.closed || !ok.closed {
+		t.Fatalf("Expected every sink to be closed")
+	}
+}
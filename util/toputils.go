@@ -5,8 +5,17 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	gnatsd "github.com/nats-io/gnatsd/server"
@@ -15,16 +24,79 @@ import (
 const DisplaySubscriptions = 1
 
 type Engine struct {
-	Host        string
-	Port        int
-	HttpClient  *http.Client
-	Uri         string
-	Conns       int
-	SortOpt     gnatsd.SortOpt
-	Delay       int
-	DisplaySubs bool
-	StatsCh     chan *Stats
-	ShutdownCh  chan struct{}
+	Host               string
+	Port               int
+	HttpClient         *http.Client
+	Uri                string
+	Username           string
+	Password           string
+	Token              string
+	Conns              int
+	SortOpt            gnatsd.SortOpt
+	Delay              int
+	Account            string
+	User               string
+	CIDRs              []*net.IPNet
+	IdleThreshold      time.Duration
+	ClusterSize        int
+	JetStreamThreshold float64
+	MinUptime          time.Duration
+	StormConns         int
+	ConnSubs           int
+	ConsumerLag        int
+	AckPending         float64
+	Lite               bool
+	StatsCh            chan *Stats
+	ShutdownCh         chan struct{}
+	Session            *Session
+	AlertLog           io.Writer
+	Cluster            string
+
+	// Servers are the monitoring endpoints of the same server or
+	// cluster, failing over to the next one when polling fails.
+	Servers []string
+
+	// ViewOptions are those of the views, which are changed by the
+	// UI via SetViewOptions once polling started.
+	ViewOptions
+
+	server             int
+	secure             bool
+	failover           string
+	resetCh            chan struct{}
+	refreshCh          chan struct{}
+	markCh             chan bool
+	pauseCh            chan bool
+	paused             bool
+	changesMu          sync.Mutex
+	changes            []change
+	nats               bool
+	flagCreds          *credentials
+	last               atomic.Value
+	mark               *Mark
+	marking            bool
+	routesPending      map[uint64]*routePending
+	routesMissingSince time.Time
+	routeIDs           map[uint64]struct{}
+	routeChanges       []time.Time
+	routeSubs          map[uint64]uint32
+	routeSubsJumps     map[uint64]*routeSubsJump
+	lastSublist        *sublistSample
+	lastUptime         time.Duration
+	restarted          bool
+	lastConnzNow       time.Time
+	storm              *connStorm
+	connSubs           map[uint64]*connSubsGrowth
+	consumerLag        map[string]*consumerLagGrowth
+	consumerAcks       map[string]*consumerAcks
+	accountConns       map[string]int
+	userConns          map[userKey]*UserConns
+	gateways           map[string]*GatewayTraffic
+	accountStats       map[string]*AccountStat
+	leafStats          map[string]*LeafInfo
+	subMsgs            map[string]int64
+	alertsSince        map[string]time.Time
+	firing             map[string]*Alert
 }
 
 func NewEngine(host string, port int, conns int, delay int) *Engine {
@@ -35,7 +107,398 @@ func NewEngine(host string, port int, conns int, delay int) *Engine {
 		Delay:      delay,
 		StatsCh:    make(chan *Stats),
 		ShutdownCh: make(chan struct{}),
+		Session:    NewSession(),
+		resetCh:    make(chan struct{}, 1),
+		refreshCh:  make(chan struct{}, 1),
+		markCh:     make(chan bool, 1),
+		pauseCh:    make(chan bool, 1),
+	}
+}
+
+// Reset restarts measuring from the next poll, forgetting about the
+// previous values used for the rates and tracking connections and routes.
+func (engine *Engine) Reset() {
+	select {
+	case engine.resetCh <- struct{}{}:
+	default:
+	}
+}
+
+// change is a change to the options of the engine, which is applied
+// by the goroutine polling between polls. Changes needing it measure
+// again from scratch afterwards.
+type change struct {
+	apply func()
+	reset bool
+}
+
+// apply changes the options of the engine before the next poll, which is
+// made right away, so that they do not change while a poll reads them.
+func (engine *Engine) apply(fn func(), reset bool) {
+	engine.changesMu.Lock()
+	engine.changes = append(engine.changes, change{apply: fn, reset: reset})
+	engine.changesMu.Unlock()
+	engine.Refresh()
+}
+
+// applyChanges applies the pending changes to the options, in the order
+// they were made, and reports whether measuring again is needed.
+func (engine *Engine) applyChanges() bool {
+	engine.changesMu.Lock()
+	changes := engine.changes
+	engine.changes = nil
+	engine.changesMu.Unlock()
+
+	var reset bool
+	for _, c := range changes {
+		c.apply()
+		reset = reset || c.reset
+	}
+	return reset
+}
+
+// ViewOptions are the options of what is displayed, which also
+// decide what is polled.
+type ViewOptions struct {
+	DisplaySubs      bool
+	DisplaySublist   bool
+	DisplayAccounts  bool
+	DisplayRoutes    bool
+	DisplayRTT       bool
+	DisplayUsers     bool
+	GroupByUser      bool
+	IdleOnly         bool
+	ListRoutes       bool
+	ListSubjects     bool
+	ListGateways     bool
+	ListLeafs        bool
+	ListJetStream    bool
+	ListAccounts     bool
+	ListAlerts       bool
+	ListAccountStats bool
+	AccountDetail    string
+	ConnDetail       uint64
+	LeafDetail       string
+}
+
+// ListingConns reports whether the connections are listed
+// instead of any of the other lists.
+func (opts ViewOptions) ListingConns() bool {
+	return !opts.ListRoutes && !opts.ListSubjects && !opts.ListGateways && !opts.ListLeafs &&
+		!opts.ListJetStream && !opts.ListAccounts && !opts.ListAlerts && !opts.ListAccountStats
+}
+
+// SetViewOptions changes the options of the views from the next
+// poll on, as the UI displays them right away.
+func (engine *Engine) SetViewOptions(opts ViewOptions) {
+	engine.apply(func() { engine.ViewOptions = opts }, false)
+}
+
+// Options are the options changed while polling, as a poll was made
+// with them, for displaying them without reading those of the engine.
+type Options struct {
+	Server  string
+	Cluster string
+	SortOpt gnatsd.SortOpt
+	Conns   int
+	Account string
+	User    string
+	Scoped  bool
+}
+
+// Options returns the current options, which are only safe to read from
+// the goroutine polling, or before polling starts.
+func (engine *Engine) Options() Options {
+	return Options{
+		Server:  engine.Uri,
+		Cluster: engine.Cluster,
+		SortOpt: engine.SortOpt,
+		Conns:   engine.Conns,
+		Account: engine.Account,
+		User:    engine.User,
+		Scoped:  engine.Scoped(),
+	}
+}
+
+// SetSortOpt sorts the connections by opt from the next poll on.
+func (engine *Engine) SetSortOpt(opt gnatsd.SortOpt) {
+	engine.apply(func() { engine.SortOpt = opt }, false)
+}
+
+// SetConns limits the connections polled to n from the next poll on.
+func (engine *Engine) SetConns(n int) {
+	engine.apply(func() { engine.Conns = n }, false)
+}
+
+// SetAccount filters the connections by account from the next poll on,
+// or clears the filter when name is empty. Totals of other connections
+// are not comparable, so measuring starts again from scratch.
+func (engine *Engine) SetAccount(name string) {
+	engine.apply(func() { engine.Account = name }, true)
+}
+
+// SetUser filters the connections by user the same way as SetAccount.
+func (engine *Engine) SetUser(name string) {
+	engine.apply(func() { engine.User = name }, true)
+}
+
+// Refresh polls the server right away instead of waiting for the delay.
+func (engine *Engine) Refresh() {
+	select {
+	case engine.refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// SetMark records the counters at the next poll when on, displaying the
+// totals relative to them from then on, or clears the mark otherwise.
+func (engine *Engine) SetMark(on bool) {
+	select {
+	case <-engine.markCh:
+	default:
+	}
+	engine.markCh <- on
+}
+
+// SetPaused stops polling the server until resumed, the stats
+// of the latest poll staying displayed in the meantime.
+func (engine *Engine) SetPaused(on bool) {
+	// Replace a pending state without blocking when set concurrently
+	for {
+		select {
+		case engine.pauseCh <- on:
+			return
+		default:
+		}
+		select {
+		case <-engine.pauseCh:
+		default:
+		}
+	}
+}
+
+// resetTrackers forgets about the connections and routes seen so far,
+// as well as the mark since their counters may not be comparable.
+func (engine *Engine) resetTrackers() {
+	engine.mark = nil
+	engine.marking = false
+	engine.routesPending = nil
+	engine.routesMissingSince = time.Time{}
+	engine.routeIDs = nil
+	engine.routeChanges = nil
+	engine.routeSubs = nil
+	engine.routeSubsJumps = nil
+	engine.lastSublist = nil
+	engine.lastUptime = 0
+	engine.restarted = false
+	engine.lastConnzNow = time.Time{}
+	engine.storm = nil
+	engine.connSubs = nil
+	engine.consumerLag = nil
+	engine.consumerAcks = nil
+	engine.accountConns = nil
+	engine.userConns = nil
+	engine.gateways = nil
+	engine.accountStats = nil
+	engine.leafStats = nil
+	engine.subMsgs = nil
+}
+
+// credentials authenticate the requests to the monitoring endpoints.
+type credentials struct {
+	username string
+	password string
+	token    string
+}
+
+// clusterSetup is what polling the servers of a cluster needs, prepared
+// ahead so that switching to it cannot fail.
+type clusterSetup struct {
+	name    string
+	servers []string
+	secure  bool
+	client  *http.Client
+	creds   *credentials
+}
+
+// SetupCluster sets up the engine for polling the servers of a cluster,
+// using https when their urls say so or certificates are set, and the
+// credentials of the cluster when set instead of those of the flags.
+func (engine *Engine) SetupCluster(cluster *Cluster) error {
+	setup, err := engine.prepareCluster(cluster)
+	if err != nil {
+		return err
+	}
+	engine.useCluster(setup)
+
+	return nil
+}
+
+// SwitchCluster switches to polling the servers of a cluster from the
+// next poll on, which is made right away, measuring again from scratch.
+func (engine *Engine) SwitchCluster(cluster *Cluster) error {
+	setup, err := engine.prepareCluster(cluster)
+	if err != nil {
+		return err
+	}
+	engine.apply(func() { engine.useCluster(setup) }, true)
+
+	return nil
+}
+
+// prepareCluster checks the servers of a cluster and loads the
+// certificates for polling them, without changing the engine.
+func (engine *Engine) prepareCluster(cluster *Cluster) (*clusterSetup, error) {
+	if engine.nats {
+		return nil, fmt.Errorf("cluster %q is polled via http, not over NATS", cluster.Name)
+	}
+	if len(cluster.Servers) == 0 {
+		return nil, fmt.Errorf("no servers in cluster %q", cluster.Name)
+	}
+
+	certs := cluster.CACert != "" || cluster.Cert != "" || cluster.SkipVerify
+	secure := certs
+	for _, server := range cluster.Servers {
+		s, _, _, err := ParseServerURL(server)
+		if err != nil {
+			return nil, err
+		}
+		secure = secure || s
+	}
+
+	setup := &clusterSetup{
+		name:    cluster.Name,
+		servers: cluster.Servers,
+		secure:  certs,
+		client:  &http.Client{},
+	}
+	if secure {
+		tlsConfig, err := newTLSConfig(cluster.CACert, cluster.Cert, cluster.Key, cluster.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		setup.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	if cluster.Username != "" || cluster.Token != "" {
+		setup.creds = &credentials{cluster.Username, cluster.Password, cluster.Token}
+	}
+
+	return setup, nil
+}
+
+// useCluster switches to polling the first server of a prepared cluster.
+func (engine *Engine) useCluster(setup *clusterSetup) {
+	// Clusters without credentials use those of the flags
+	if engine.flagCreds == nil {
+		engine.flagCreds = &credentials{engine.Username, engine.Password, engine.Token}
+	}
+	creds := setup.creds
+	if creds == nil {
+		creds = engine.flagCreds
+	}
+	engine.Username, engine.Password, engine.Token = creds.username, creds.password, creds.token
+
+	engine.HttpClient = setup.client
+	engine.Cluster = setup.name
+	engine.Servers = setup.servers
+	engine.secure = setup.secure
+	engine.failover = ""
+	engine.useServer(0)
+}
+
+// SetupServers sets the monitoring endpoints to poll starting with the
+// first one, which are polled via https when secure is set regardless
+// of their urls.
+func (engine *Engine) SetupServers(servers []string, secure bool) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("no servers to monitor")
+	}
+	for _, server := range servers {
+		if _, _, _, err := ParseServerURL(server); err != nil {
+			return err
+		}
+	}
+
+	engine.Servers = servers
+	engine.secure = secure
+	engine.failover = ""
+	engine.useServer(0)
+
+	return nil
+}
+
+// useServer switches to polling one of the servers.
+func (engine *Engine) useServer(i int) {
+	secure, host, port, _ := ParseServerURL(engine.Servers[i])
+	scheme := "http"
+	if secure || engine.secure {
+		scheme = "https"
+	}
+
+	engine.server = i
+	engine.Host = host
+	engine.Port = port
+	engine.Uri = fmt.Sprintf("%s://%s:%d", scheme, host, port)
+}
+
+// Failover switches to polling the next one of the servers, measuring
+// again from scratch since the counters of each server are different.
+// It returns false when there is no other server to switch to.
+func (engine *Engine) Failover() bool {
+	if len(engine.Servers) < 2 {
+		return false
+	}
+
+	from := engine.Uri
+	engine.useServer((engine.server + 1) % len(engine.Servers))
+	engine.failover = fmt.Sprintf("failed over from %s to %s at %s",
+		from, engine.Uri, time.Now().Format("15:04:05"))
+	engine.Reset()
+
+	return true
+}
+
+// pollFailed reports the error of a poll, failing over to the
+// next server to be polled in case there is one.
+func (engine *Engine) pollFailed(stats *Stats, err error) {
+	stats.Error = err
+	engine.Failover()
+	stats.Failover = engine.failover
+	engine.StatsCh <- stats
+}
+
+// ParseServerURL parses the monitoring endpoint of a server given as
+// host:port or as an http or https url, using port 8222 by default.
+func ParseServerURL(server string) (secure bool, host string, port int, err error) {
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return false, "", 0, fmt.Errorf("invalid server %q: %v", server, err)
+	}
+
+	switch u.Scheme {
+	case "http":
+	case "https":
+		secure = true
+	default:
+		return false, "", 0, fmt.Errorf("invalid server %q: unsupported scheme %s", server, u.Scheme)
+	}
+
+	host, port = u.Host, 8222
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host = h
+		_, err = fmt.Sscanf(p, "%d", &port)
+		if err != nil {
+			return false, "", 0, fmt.Errorf("invalid server %q: invalid port %s", server, p)
+		}
 	}
+	if host == "" {
+		return false, "", 0, fmt.Errorf("invalid server %q: missing host", server)
+	}
+
+	return secure, host, port, nil
 }
 
 // Request takes a path and options, and returns a Stats struct
@@ -49,39 +512,213 @@ func (engine *Engine) Request(path string) (interface{}, error) {
 		statz = &gnatsd.Varz{}
 	case "/connz":
 		statz = &gnatsd.Connz{}
-		uri += fmt.Sprintf("?limit=%d&sort=%s", engine.Conns, engine.SortOpt)
-		if engine.DisplaySubs {
-			uri += fmt.Sprintf("&subs=%d", DisplaySubscriptions)
-		}
+		uri = engine.connzURI()
+	case "/subsz":
+		statz = &gnatsd.Subsz{}
+		uri = engine.subszURI()
+	case "/routez":
+		statz = &gnatsd.Routez{}
+	case "/jsz":
+		statz = &Jsz{}
+		uri = engine.jszURI()
+	case "/gatewayz":
+		statz = &Gatewayz{}
+	case "/leafz":
+		statz = &Leafz{}
+		uri = engine.leafzURI()
+	case "/accstatz":
+		statz = &Accstatz{}
+	case "/accountz":
+		statz = &Accountz{}
+		uri = engine.accountzURI()
 	default:
 		return nil, fmt.Errorf("invalid path '%s' for stats server", path)
 	}
 
-	resp, err := engine.HttpClient.Get(uri)
+	err := engine.requestURI(uri, statz)
+	if err != nil {
+		return nil, err
+	}
+
+	return statz, nil
+}
+
+// Scoped reports whether the polled connections are limited to an
+// account, user or subnets, so that the server wide counters do not apply.
+func (engine *Engine) Scoped() bool {
+	return engine.Account != "" || engine.User != "" || len(engine.CIDRs) > 0
+}
+
+// connzURI returns the uri for polling /connz with the current options.
+func (engine *Engine) connzURI() string {
+	uri := engine.Uri + "/connz"
+	// Options unknown to gnatsd are sorted by once polled, or only
+	// apply to JetStream consumers for lag, so connections are
+	// requested in default order for them.
+	sortOpt := engine.SortOpt
+	if !sortOpt.IsValid() {
+		sortOpt = ""
+	}
+	// The most idle connections are requested first so that
+	// they are not left out by the limit when listing them.
+	if engine.IdleOnly {
+		sortOpt = "idle"
+	}
+	uri += fmt.Sprintf("?limit=%d&sort=%s", engine.Conns, sortOpt)
+	if engine.ConnDetail != 0 {
+		uri += fmt.Sprintf("&cid=%d&subs=detail", engine.ConnDetail)
+	} else if engine.DisplaySubs {
+		uri += fmt.Sprintf("&subs=%d", DisplaySubscriptions)
+	}
+	if engine.Account != "" {
+		uri += fmt.Sprintf("&acc=%s", url.QueryEscape(engine.Account))
+	}
+	if engine.User != "" {
+		uri += fmt.Sprintf("&user=%s", url.QueryEscape(engine.User))
+	}
+	if engine.DisplayAccounts || engine.DisplayUsers || engine.GroupByUser || engine.User != "" {
+		uri += "&auth=true"
+	}
+	return uri
+}
+
+// jszURI returns the uri for polling /jsz, along with the
+// accounts, streams and consumers when listing them or
+// alerting on the lag of the consumers, of the filtered
+// account only when set.
+func (engine *Engine) jszURI() string {
+	query := url.Values{}
+	if engine.ListJetStream || (engine.alertsOnConsumers() && !engine.Lite) {
+		query.Set("accounts", "true")
+		query.Set("streams", "true")
+		query.Set("consumers", "true")
+		query.Set("config", "true")
+	}
+	if engine.Account != "" {
+		query.Set("acc", engine.Account)
+	}
+	return withQuery(engine.Uri+"/jsz", query)
+}
+
+// accountzURI returns the uri for polling /accountz, with the
+// detail of a single account instead of the list when selected.
+func (engine *Engine) accountzURI() string {
+	uri := engine.Uri + "/accountz"
+	if engine.AccountDetail != "" {
+		uri += fmt.Sprintf("?acc=%s", url.QueryEscape(engine.AccountDetail))
+	}
+	return uri
+}
+
+// subszURI returns the uri for polling /subsz, listing up to
+// the limit of connections when the subjects are displayed,
+// of the filtered account only when set.
+func (engine *Engine) subszURI() string {
+	query := url.Values{}
+	if engine.ListSubjects {
+		query.Set("subs", "1")
+		query.Set("limit", strconv.Itoa(engine.Conns))
+	}
+	if engine.Account != "" {
+		query.Set("acc", engine.Account)
+	}
+	return withQuery(engine.Uri+"/subsz", query)
+}
+
+// withQuery appends the query to the uri unless empty.
+func withQuery(uri string, query url.Values) string {
+	if len(query) == 0 {
+		return uri
+	}
+	return uri + "?" + query.Encode()
+}
+
+// leafzURI returns the uri for polling /leafz, along with the
+// subscriptions of the leafnodes when listing those of one.
+func (engine *Engine) leafzURI() string {
+	uri := engine.Uri + "/leafz"
+	if engine.LeafDetail != "" {
+		uri += "?subs=1"
+	}
+	return uri
+}
+
+// get requests the uri, authenticating when credentials are set
+// for endpoints behind a proxy requiring them.
+func (engine *Engine) get(uri string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if engine.Username != "" {
+		req.SetBasicAuth(engine.Username, engine.Password)
+	} else if engine.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+engine.Token)
+	}
+	return engine.HttpClient.Do(req)
+}
+
+// requestURI gets the uri and decodes the json response into each one
+// of the given values, which allows decoding the fields from newer
+// servers that the vendored gnatsd types are missing.
+func (engine *Engine) requestURI(uri string, statz ...interface{}) error {
+	resp, err := engine.get(uri)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
-		return nil, fmt.Errorf("could not get stats from server: %v\n", err)
+		return &RequestError{URI: uri, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &RequestError{URI: uri, StatusCode: resp.StatusCode}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("could not read response body: %v\n", err)
+		return fmt.Errorf("could not read response body: %v", err)
+	}
+
+	for _, v := range statz {
+		err = json.Unmarshal(body, v)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal json: %v", err)
+		}
 	}
 
-	err = json.Unmarshal(body, &statz)
+	return nil
+}
+
+// requestHealth polls /healthz, which responds with the reason along
+// with an error status when the server is unhealthy. Servers without
+// it do not report their health.
+func (engine *Engine) requestHealth() *Healthz {
+	uri := engine.Uri + "/healthz"
+	resp, err := engine.get(uri)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json: %v\n", err)
+		return &Healthz{Status: HealthUnknown, Error: err.Error()}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
 	}
 
-	return statz, nil
+	health := &Healthz{}
+	if err := json.NewDecoder(resp.Body).Decode(health); err != nil || health.Status == "" {
+		return &Healthz{
+			Status: HealthUnknown,
+			Error:  fmt.Sprintf("responded with %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		}
+	}
+	return health
 }
 
 // MonitorStats is ran as a goroutine and takes options
 // which can modify how poll values then sends to channel.
 func (engine *Engine) MonitorStats() error {
 	var pollTime time.Time
+	var serverTime time.Time
 
 	var inMsgsDelta int64
 	var outMsgsDelta int64
@@ -101,6 +738,12 @@ func (engine *Engine) MonitorStats() error {
 	first := true
 	pollTime = time.Now()
 
+	// Time taken by the previous poll, which is subtracted from the
+	// delay of the next one so that polls start every interval. Polls
+	// slower than the interval are not queued, the next one starts
+	// right away instead.
+	var took time.Duration
+
 	delay := time.Duration(engine.Delay) * time.Second
 
 	for {
@@ -111,133 +754,1468 @@ func (engine *Engine) MonitorStats() error {
 			Error: fmt.Errorf(""),
 		}
 
+		// Measure again from scratch, e.g. after switching servers
+		select {
+		case <-engine.resetCh:
+			first = true
+			inMsgsRate, outMsgsRate, inBytesRate, outBytesRate = 0, 0, 0, 0
+			engine.resetTrackers()
+		default:
+		}
+
+		select {
+		case on := <-engine.markCh:
+			engine.mark = nil
+			engine.marking = on
+			// Totals of users drop when marking
+			engine.userConns = nil
+		default:
+		}
+
+		wait := delay - took
+		if wait < 0 {
+			wait = 0
+		}
 		select {
 		case <-engine.ShutdownCh:
 			return nil
-		case <-time.After(delay):
-			// Get /varz
-			{
-				result, err := engine.Request("/varz")
-				if err != nil {
-					stats.Error = err
-					engine.StatsCh <- stats
-					continue
-				}
-				if varz, ok := result.(*gnatsd.Varz); ok {
-					stats.Varz = varz
-				}
+		case <-time.After(wait):
+		case <-engine.refreshCh:
+		case engine.paused = <-engine.pauseCh:
+		}
+
+		// Hold off polling while paused, until resumed
+		for engine.paused {
+			select {
+			case <-engine.ShutdownCh:
+				return nil
+			case engine.paused = <-engine.pauseCh:
 			}
+		}
 
-			// Get /connz
-			{
-				result, err := engine.Request("/connz")
-				if err != nil {
-					stats.Error = err
-					engine.StatsCh <- stats
-					continue
-				}
-				if connz, ok := result.(*gnatsd.Connz); ok {
-					stats.Connz = connz
+		// Options changed in the meantime are applied before polling
+		if engine.applyChanges() {
+			first = true
+			inMsgsRate, outMsgsRate, inBytesRate, outBytesRate = 0, 0, 0, 0
+			engine.resetTrackers()
+		}
+		stats.Options = engine.Options()
+		stats.Failover = engine.failover
+		pollStart := time.Now()
+
+		// Get /varz
+		{
+			varz := &gnatsd.Varz{}
+			statz := []interface{}{varz}
+			var extVarz *ExtVarz
+			if !engine.Lite {
+				extVarz = &ExtVarz{}
+				statz = append(statz, extVarz)
+			}
+			err := engine.requestURI(engine.Uri+"/varz", statz...)
+			if err != nil {
+				engine.pollFailed(stats, err)
+				continue
+			}
+			stats.Varz = varz
+			stats.ExtVarz = extVarz
+		}
+
+		// Rates are measured between the times the counters were
+		// sampled, rather than after the slower requests finish.
+		sampled := time.Now()
+
+		// Get /connz
+		{
+			connz := &gnatsd.Connz{}
+			statz := []interface{}{connz}
+			var extConnz *ExtConnz
+			if !engine.Lite {
+				extConnz = &ExtConnz{}
+				statz = append(statz, extConnz)
+			}
+			err := engine.requestURI(engine.connzURI(), statz...)
+			if err != nil {
+				engine.pollFailed(stats, err)
+				continue
+			}
+			// Servers not filtering by user include every connection
+			if engine.User != "" {
+				filterConns(connz, extConnz, func(conn *gnatsd.ConnInfo, _ *ExtConnInfo) bool {
+					return conn.AuthorizedUser == engine.User
+				})
+			}
+			if len(engine.CIDRs) > 0 {
+				filterConns(connz, extConnz, func(conn *gnatsd.ConnInfo, _ *ExtConnInfo) bool {
+					return IPInNets(conn.IP, engine.CIDRs)
+				})
+			}
+			if engine.IdleOnly {
+				now := connz.Now
+				if now.IsZero() {
+					now = time.Now()
 				}
+				filterConns(connz, extConnz, func(conn *gnatsd.ConnInfo, _ *ExtConnInfo) bool {
+					idle, ok := IdleTime(conn, now)
+					return ok && idle >= engine.IdleThreshold
+				})
+			}
+			sortConns(engine.SortOpt, connz, extConnz)
+			stats.Connz = connz
+			stats.ExtConnz = extConnz
+			if engine.Scoped() {
+				sampled = time.Now()
 			}
+		}
 
-			// Periodic snapshot to get per sec metrics
-			inMsgsVal := stats.Varz.InMsgs
-			outMsgsVal := stats.Varz.OutMsgs
-			inBytesVal := stats.Varz.InBytes
-			outBytesVal := stats.Varz.OutBytes
-
-			inMsgsDelta = inMsgsVal - inMsgsLastVal
-			outMsgsDelta = outMsgsVal - outMsgsLastVal
-			inBytesDelta = inBytesVal - inBytesLastVal
-			outBytesDelta = outBytesVal - outBytesLastVal
-
-			inMsgsLastVal = inMsgsVal
-			outMsgsLastVal = outMsgsVal
-			inBytesLastVal = inBytesVal
-			outBytesLastVal = outBytesVal
-
-			now := time.Now()
-			tdelta := now.Sub(pollTime)
-			pollTime = now
-
-			// Calculate rates but the first time
-			if first {
-				first = false
-			} else {
-				inMsgsRate = float64(inMsgsDelta) / tdelta.Seconds()
-				outMsgsRate = float64(outMsgsDelta) / tdelta.Seconds()
-				inBytesRate = float64(inBytesDelta) / tdelta.Seconds()
-				outBytesRate = float64(outBytesDelta) / tdelta.Seconds()
+		// Get /routez
+		if !engine.Lite || engine.ListRoutes {
+			result, err := engine.Request("/routez")
+			if err != nil {
+				engine.pollFailed(stats, err)
+				continue
+			}
+			if routez, ok := result.(*gnatsd.Routez); ok {
+				stats.Routez = routez
 			}
+		}
 
-			stats.Rates = &Rates{
-				InMsgsRate:   inMsgsRate,
-				OutMsgsRate:  outMsgsRate,
-				InBytesRate:  inBytesRate,
-				OutBytesRate: outBytesRate,
+		// Get /subsz
+		if (engine.DisplaySublist && !engine.Lite) || engine.ListSubjects {
+			subsz := &gnatsd.Subsz{}
+			extSubsz := &ExtSubsz{}
+			err := engine.requestURI(engine.subszURI(), subsz, extSubsz)
+			if err != nil {
+				engine.pollFailed(stats, err)
+				continue
+			}
+			stats.Subsz = subsz
+			stats.ExtSubsz = extSubsz
+			stats.SubsChurn = engine.subsChurn(subsz.SublistStats, time.Now())
+			if engine.ListSubjects {
+				stats.SubjectSubs = CountSubjectSubs(extSubsz.Subs)
 			}
+		}
 
-			engine.StatsCh <- stats
+		// Get /jsz, though not every server has JetStream
+		// enabled so failing to get it is not an error.
+		if ((engine.JetStreamThreshold > 0 || engine.alertsOnConsumers()) && !engine.Lite) || engine.ListJetStream {
+			result, err := engine.Request("/jsz")
+			if err == nil {
+				if jsz, ok := result.(*Jsz); ok {
+					for _, acc := range jsz.AccountDetails {
+						for _, stream := range acc.Streams {
+							sortConsumers(engine.SortOpt, stream.Consumers)
+						}
+					}
+					stats.Jsz = jsz
+				}
+			}
 		}
-	}
-}
 
-// SetupHTTPS sets up the http client and uri to use for polling.
-func (engine *Engine) SetupHTTPS(caCertOpt, certOpt, keyOpt string, skipVerifyOpt bool) error {
-	tlsConfig := &tls.Config{}
-	if caCertOpt != "" {
-		caCert, err := ioutil.ReadFile(caCertOpt)
-		if err != nil {
-			return err
+		// Get /gatewayz, which older servers do not have
+		if engine.ListGateways {
+			result, err := engine.Request("/gatewayz")
+			if err == nil {
+				if gatewayz, ok := result.(*Gatewayz); ok {
+					stats.Gatewayz = gatewayz
+				}
+			}
 		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-		tlsConfig.RootCAs = caCertPool
-	}
 
-	if certOpt != "" && keyOpt != "" {
-		cert, err := tls.LoadX509KeyPair(certOpt, keyOpt)
-		if err != nil {
-			return err
+		// Get /leafz, which older servers do not have either
+		if engine.ListLeafs {
+			result, err := engine.Request("/leafz")
+			if err == nil {
+				if leafz, ok := result.(*Leafz); ok {
+					sortLeafs(engine.SortOpt, leafz.Leafs)
+					stats.Leafz = leafz
+				}
+			}
 		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
-	}
 
-	if skipVerifyOpt {
-		tlsConfig.InsecureSkipVerify = true
-	}
+		// Get /healthz, which older servers do not have either
+		if !engine.Lite {
+			stats.Healthz = engine.requestHealth()
+		}
 
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	engine.HttpClient = &http.Client{Transport: transport}
-	engine.Uri = fmt.Sprintf("https://%s:%d", engine.Host, engine.Port)
+		// Get /accstatz, which older servers do not have either
+		if engine.ListAccountStats {
+			result, err := engine.Request("/accstatz")
+			if err == nil {
+				if accstatz, ok := result.(*Accstatz); ok {
+					stats.Accstatz = accstatz
+				}
+			}
+		}
 
-	return nil
-}
+		// Get /accountz, which older servers do not have either
+		if engine.ListAccounts {
+			result, err := engine.Request("/accountz")
+			if err == nil {
+				if accountz, ok := result.(*Accountz); ok {
+					sort.Strings(accountz.Accounts)
+					stats.Accountz = accountz
+				}
+			}
+		}
 
-// SetupHTTP sets up the http client and uri to use for polling.
-func (engine *Engine) SetupHTTP() {
-	engine.HttpClient = &http.Client{}
-	engine.Uri = fmt.Sprintf("http://%s:%d", engine.Host, engine.Port)
+		// Periodic snapshot to get per sec metrics
+		inMsgsVal := stats.Varz.InMsgs
+		outMsgsVal := stats.Varz.OutMsgs
+		inBytesVal := stats.Varz.InBytes
+		outBytesVal := stats.Varz.OutBytes
 
-	return
-}
+		// Server counters include every connection, so when scoped
+		// to an account or user use the totals of its connections.
+		if engine.Scoped() {
+			inMsgsVal, outMsgsVal, inBytesVal, outBytesVal = ConnzTotals(stats.Connz)
+		}
 
-// Stats represents the monitored data from a NATS server.
-type Stats struct {
-	Varz  *gnatsd.Varz
-	Connz *gnatsd.Connz
-	Rates *Rates
-	Error error
-}
+		inMsgsDelta = inMsgsVal - inMsgsLastVal
+		outMsgsDelta = outMsgsVal - outMsgsLastVal
+		inBytesDelta = inBytesVal - inBytesLastVal
+		outBytesDelta = outBytesVal - outBytesLastVal
+
+		inMsgsLastVal = inMsgsVal
+		outMsgsLastVal = outMsgsVal
+		inBytesLastVal = inBytesVal
+		outBytesLastVal = outBytesVal
+
+		// Display the totals since the mark, once taken
+		if engine.marking {
+			engine.mark = NewMark(stats.Varz, stats.Connz, time.Now())
+			engine.marking = false
+		}
+		if engine.mark != nil {
+			engine.mark.Apply(stats.Varz, stats.Connz)
+			stats.Mark = engine.mark
+		}
+
+		// The counters are timestamped by the server, in the
+		// connz response when scoped to some of the connections.
+		serverNow := stats.Varz.Now
+		if engine.Scoped() {
+			serverNow = stats.Connz.Now
+		}
+		var serverDelta time.Duration
+		if !serverNow.IsZero() && !serverTime.IsZero() {
+			serverDelta = serverNow.Sub(serverTime)
+		}
+		serverTime = serverNow
+
+		now := time.Now()
+		tdelta := RateInterval(serverDelta, sampled.Sub(pollTime))
+		pollTime = sampled
+		took = now.Sub(pollStart)
+
+		if stats.Routez != nil && !engine.Lite {
+			stats.Alerts = append(stats.Alerts, engine.checkRoutes(stats.Routez, now)...)
+			stats.Alerts = append(stats.Alerts, engine.checkRouteCount(stats.Routez, now)...)
+			stats.RouteSubs = engine.countRouteSubs(stats.Routez)
+			stats.Alerts = append(stats.Alerts, engine.checkRouteSubs(stats.RouteSubs, now)...)
+		}
+		// The JetStream view polls /jsz as well, which is not an opt-in
+		// to the usage alerts
+		if engine.JetStreamThreshold > 0 && !engine.Lite {
+			stats.Alerts = append(stats.Alerts, engine.checkJetStream(stats.Jsz, now)...)
+		}
+		if !engine.Lite {
+			stats.Alerts = append(stats.Alerts, engine.checkUptime(stats.Varz, now)...)
+			stats.Alerts = append(stats.Alerts, engine.checkPollDuration(took, now)...)
+			stats.Alerts = append(stats.Alerts, engine.checkConnStorm(stats.Connz, now)...)
+			stats.Alerts = append(stats.Alerts, engine.checkConnSubs(stats.Connz, now)...)
+			stats.Alerts = append(stats.Alerts, engine.checkConsumerLag(stats.Jsz, now)...)
+			stats.Alerts = append(stats.Alerts, engine.checkConsumerAcks(stats.Jsz, now)...)
+		}
+		stats.AlertEvents = engine.alertEvents(stats.Alerts, now)
+
+		// Failing to log the events is shown as an alert, which is
+		// not an event itself so it is not written to the log again.
+		if engine.AlertLog != nil {
+			if err := writeAlertEvents(engine.AlertLog, engine.Uri, stats.AlertEvents); err != nil {
+				stats.Alerts = append(stats.Alerts, &Alert{
+					Condition: "alert_log",
+					Message:   fmt.Sprintf("could not write alert events: %s", err),
+					Since:     now,
+				})
+			}
+		}
+
+		if engine.DisplayAccounts && stats.ExtConnz != nil {
+			stats.AccountConns = engine.countAccountConns(stats.ExtConnz)
+		}
+
+		if engine.GroupByUser {
+			stats.UserConns = engine.groupUserConns(stats.Connz, stats.ExtConnz, tdelta)
+		} else {
+			engine.userConns = nil
+		}
+
+		if engine.ListGateways && stats.Gatewayz != nil {
+			stats.Gateways = engine.gatewayTraffic(stats.Gatewayz, tdelta)
+		} else {
+			engine.gateways = nil
+		}
+
+		if engine.ListAccountStats && stats.Accstatz != nil {
+			stats.AccountTraffic = engine.accountTraffic(stats.Accstatz, tdelta)
+		} else {
+			engine.accountStats = nil
+		}
+
+		if engine.ListLeafs && stats.Leafz != nil {
+			stats.LeafTraffic = engine.leafTraffic(stats.Leafz, tdelta)
+		} else {
+			engine.leafStats = nil
+		}
+
+		if engine.ConnDetail != 0 && stats.ExtConnz != nil && len(stats.ExtConnz.Conns) == 1 && len(stats.Connz.Conns) == 1 {
+			stats.SubTraffic = engine.subTraffic(&stats.Connz.Conns[0], &stats.ExtConnz.Conns[0], tdelta)
+		} else {
+			engine.subMsgs = nil
+		}
+
+		// Calculate rates but the first time
+		if first {
+			first = false
+			stats.Baseline = true
+		} else {
+			inMsgsRate = float64(inMsgsDelta) / tdelta.Seconds()
+			outMsgsRate = float64(outMsgsDelta) / tdelta.Seconds()
+			inBytesRate = float64(inBytesDelta) / tdelta.Seconds()
+			outBytesRate = float64(outBytesDelta) / tdelta.Seconds()
+		}
+
+		stats.Rates = &Rates{
+			InMsgsRate:   inMsgsRate,
+			OutMsgsRate:  outMsgsRate,
+			InBytesRate:  inBytesRate,
+			OutBytesRate: outBytesRate,
+		}
+
+		if engine.Session != nil {
+			engine.Session.Update(stats)
+		}
+
+		engine.last.Store(stats)
+		engine.StatsCh <- stats
+	}
+}
+
+// SetupHTTPS sets up the http client and uri to use for polling.
+func (engine *Engine) SetupHTTPS(caCertOpt, certOpt, keyOpt string, skipVerifyOpt bool) error {
+	tlsConfig, err := newTLSConfig(caCertOpt, certOpt, keyOpt, skipVerifyOpt)
+	if err != nil {
+		return err
+	}
+
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	engine.HttpClient = &http.Client{Transport: transport}
+	engine.Uri = fmt.Sprintf("https://%s:%d", engine.Host, engine.Port)
+
+	return nil
+}
+
+// newTLSConfig loads the root CA and client certificates to verify
+// and authenticate with the servers.
+func newTLSConfig(caCertOpt, certOpt, keyOpt string, skipVerifyOpt bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caCertOpt != "" {
+		caCert, err := ioutil.ReadFile(caCertOpt)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertOpt)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	// Client certificates are presented to endpoints requiring mTLS
+	if (certOpt == "") != (keyOpt == "") {
+		return nil, fmt.Errorf("both the client certificate and its key are needed")
+	}
+	if certOpt != "" {
+		cert, err := tls.LoadX509KeyPair(certOpt, keyOpt)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if skipVerifyOpt {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// SetupHTTP sets up the http client and uri to use for polling.
+func (engine *Engine) SetupHTTP() {
+	engine.HttpClient = &http.Client{}
+	engine.Uri = fmt.Sprintf("http://%s:%d", engine.Host, engine.Port)
+
+	return
+}
+
+// Stats represents the monitored data from a NATS server.
+type Stats struct {
+	Varz           *gnatsd.Varz
+	ExtVarz        *ExtVarz
+	Connz          *gnatsd.Connz
+	ExtConnz       *ExtConnz
+	Subsz          *gnatsd.Subsz
+	ExtSubsz       *ExtSubsz
+	Routez         *gnatsd.Routez
+	Jsz            *Jsz
+	Healthz        *Healthz
+	Gatewayz       *Gatewayz
+	Leafz          *Leafz
+	Accountz       *Accountz
+	Rates          *Rates
+	Alerts         []*Alert
+	AlertEvents    []*AlertEvent
+	AccountConns   []*AccountConns
+	UserConns      []*UserConns
+	RouteSubs      []*RouteSubs
+	SubsChurn      *SubsChurn
+	SubjectSubs    []*SubjectSubs
+	Gateways       []*GatewayTraffic
+	Accstatz       *Accstatz
+	AccountTraffic []*AccountTraffic
+	LeafTraffic    []*LeafTraffic
+	SubTraffic     []*SubTraffic
+	Error          error
+
+	// Failover notes the last switch to another server, if any.
+	Failover string
+
+	// Baseline is set on the first poll since measuring from scratch,
+	// e.g. after a failover, which has no rates yet.
+	Baseline bool
+
+	// Options the poll was made with.
+	Options Options
+
+	// Mark the totals are relative to, if any.
+	Mark *Mark
+}
+
+// ErrorText returns the error from polling the stats as a single
+// line, or an empty string if there was none.
+func (stats *Stats) ErrorText() string {
+	if stats.Error == nil {
+		return ""
+	}
+	return stats.Error.Error()
+}
+
+// Mark are the cumulative counters of the server and its connections
+// at some point, which the totals are then displayed relative to.
+type Mark struct {
+	Time     time.Time
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+
+	conns map[uint64]gnatsd.ConnInfo
+}
+
+// NewMark records the counters of the server and its connections.
+func NewMark(varz *gnatsd.Varz, connz *gnatsd.Connz, now time.Time) *Mark {
+	mark := &Mark{
+		Time:     now,
+		InMsgs:   varz.InMsgs,
+		OutMsgs:  varz.OutMsgs,
+		InBytes:  varz.InBytes,
+		OutBytes: varz.OutBytes,
+		conns:    make(map[uint64]gnatsd.ConnInfo),
+	}
+	for _, conn := range connz.Conns {
+		mark.conns[conn.Cid] = conn
+	}
+	return mark
+}
+
+// Apply makes the counters relative to the mark. Connections
+// created after the mark keep their counters as they are.
+func (m *Mark) Apply(varz *gnatsd.Varz, connz *gnatsd.Connz) {
+	varz.InMsgs -= m.InMsgs
+	varz.OutMsgs -= m.OutMsgs
+	varz.InBytes -= m.InBytes
+	varz.OutBytes -= m.OutBytes
+
+	for i := range connz.Conns {
+		conn := &connz.Conns[i]
+		marked, ok := m.conns[conn.Cid]
+		if !ok {
+			continue
+		}
+		conn.InMsgs -= marked.InMsgs
+		conn.OutMsgs -= marked.OutMsgs
+		conn.InBytes -= marked.InBytes
+		conn.OutBytes -= marked.OutBytes
+	}
+}
 
 // Rates represents the tracked in/out msgs and bytes flow
 // from a NATS server.
 type Rates struct {
-	InMsgsRate   float64
-	OutMsgsRate  float64
-	InBytesRate  float64
-	OutBytesRate float64
+	InMsgsRate   float64 `json:"in_msgs_rate"`
+	OutMsgsRate  float64 `json:"out_msgs_rate"`
+	InBytesRate  float64 `json:"in_bytes_rate"`
+	OutBytesRate float64 `json:"out_bytes_rate"`
+}
+
+// AccountConns is the number of polled connections from an account.
+type AccountConns struct {
+	Account string
+	Conns   int
+	Delta   int
+}
+
+// SubsChurn is the rate of subscriptions added to and removed
+// from the sublist of the server.
+type SubsChurn struct {
+	AddedRate   float64
+	RemovedRate float64
+}
+
+// sublistSample is the number of inserts and removes
+// of the sublist at the time of a poll.
+type sublistSample struct {
+	inserts uint64
+	removes uint64
+	time    time.Time
+}
+
+// subsChurn compares the inserts and removes of the sublist against
+// the previous poll, being unknown until there is one to compare against.
+func (engine *Engine) subsChurn(sl *gnatsd.SublistStats, now time.Time) *SubsChurn {
+	if sl == nil {
+		return nil
+	}
+
+	last := engine.lastSublist
+	engine.lastSublist = &sublistSample{inserts: sl.NumInserts, removes: sl.NumRemoves, time: now}
+	if last == nil || sl.NumInserts < last.inserts || sl.NumRemoves < last.removes {
+		return nil
+	}
+	tdelta := now.Sub(last.time).Seconds()
+	if tdelta <= 0 {
+		return nil
+	}
+
+	return &SubsChurn{
+		AddedRate:   float64(sl.NumInserts-last.inserts) / tdelta,
+		RemovedRate: float64(sl.NumRemoves-last.removes) / tdelta,
+	}
+}
+
+// RouteSubs is the number of subscriptions propagated over a route,
+// along with the change since the previous poll.
+type RouteSubs struct {
+	Rid    uint64
+	Remote string
+	Subs   uint32
+	Delta  int64
+}
+
+// countRouteSubs compares the subscriptions of each route against the
+// previous poll, with new routes having no change the first time.
+func (engine *Engine) countRouteSubs(routez *gnatsd.Routez) []*RouteSubs {
+	subs := make(map[uint64]uint32)
+	var routes []*RouteSubs
+	for _, route := range routez.Routes {
+		rs := &RouteSubs{
+			Rid:    route.Rid,
+			Remote: fmt.Sprintf("%s:%d", route.IP, route.Port),
+			Subs:   route.NumSubs,
+		}
+		if last, ok := engine.routeSubs[route.Rid]; ok {
+			rs.Delta = int64(route.NumSubs) - int64(last)
+		}
+		subs[route.Rid] = route.NumSubs
+		routes = append(routes, rs)
+	}
+	engine.routeSubs = subs
+
+	return routes
+}
+
+// GatewayTraffic is the traffic with a remote gateway, summed over
+// the outbound and inbound connections with it.
+type GatewayTraffic struct {
+	Name     string
+	Outbound int
+	Inbound  int
+	Pending  int
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+	Rates    *Rates
+}
+
+// gatewayTraffic sums the traffic with each remote gateway, sorted by
+// name, and compares it against the previous poll for the rates.
+func (engine *Engine) gatewayTraffic(gatewayz *Gatewayz, tdelta time.Duration) []*GatewayTraffic {
+	traffic := make(map[string]*GatewayTraffic)
+	get := func(name string) *GatewayTraffic {
+		gt, ok := traffic[name]
+		if !ok {
+			gt = &GatewayTraffic{Name: name, Rates: &Rates{}}
+			traffic[name] = gt
+		}
+		return gt
+	}
+	add := func(gt *GatewayTraffic, conn *GatewayConnz) {
+		gt.Pending += conn.Pending
+		gt.InMsgs += conn.InMsgs
+		gt.OutMsgs += conn.OutMsgs
+		gt.InBytes += conn.InBytes
+		gt.OutBytes += conn.OutBytes
+	}
+	for name, remote := range gatewayz.OutboundGateways {
+		gt := get(name)
+		if remote != nil && remote.Connection != nil {
+			gt.Outbound++
+			add(gt, remote.Connection)
+		}
+	}
+	for name, remotes := range gatewayz.InboundGateways {
+		gt := get(name)
+		for _, remote := range remotes {
+			if remote != nil && remote.Connection != nil {
+				gt.Inbound++
+				add(gt, remote.Connection)
+			}
+		}
+	}
+
+	// Connections closing between polls would make the rates negative
+	rate := func(val, lastVal int64) float64 {
+		if val < lastVal || tdelta <= 0 {
+			return 0
+		}
+		return float64(val-lastVal) / tdelta.Seconds()
+	}
+	var gateways []*GatewayTraffic
+	for name, gt := range traffic {
+		if last, ok := engine.gateways[name]; ok {
+			gt.Rates = &Rates{
+				InMsgsRate:   rate(gt.InMsgs, last.InMsgs),
+				OutMsgsRate:  rate(gt.OutMsgs, last.OutMsgs),
+				InBytesRate:  rate(gt.InBytes, last.InBytes),
+				OutBytesRate: rate(gt.OutBytes, last.OutBytes),
+			}
+		}
+		gateways = append(gateways, gt)
+	}
+	engine.gateways = traffic
+
+	sort.Sort(byGatewayName(gateways))
+
+	return gateways
+}
+
+// SubTraffic is the delivery of a subscription of a connection, with
+// the share of the pending bytes of the connection estimated to be
+// for it, since servers only report the pending bytes of connections.
+type SubTraffic struct {
+	SubDetails
+	MsgsRate float64
+	Share    float64
+	Pending  int64
+}
+
+// subTraffic measures the delivery rate of each subscription of the
+// connection, sorted by the messages delivered. The pending bytes of the
+// connection are split among its subscriptions by their share of the
+// messages delivered since the previous poll, or of all the messages
+// delivered when none were, pinpointing the subject a slow consumer is
+// most likely stuck on.
+func (engine *Engine) subTraffic(conn *gnatsd.ConnInfo, ext *ExtConnInfo, tdelta time.Duration) []*SubTraffic {
+	tracked := make(map[string]int64)
+	var subs []*SubTraffic
+	var total, delivered float64
+	for _, sub := range ext.SubsDetail {
+		// Subscription ids are only unique within a connection
+		key := fmt.Sprintf("%d/%s", conn.Cid, sub.Sid)
+		st := &SubTraffic{SubDetails: sub}
+		if last, ok := engine.subMsgs[key]; ok && sub.Msgs >= last && tdelta > 0 {
+			st.MsgsRate = float64(sub.Msgs-last) / tdelta.Seconds()
+		}
+		tracked[key] = sub.Msgs
+		total += float64(sub.Msgs)
+		delivered += st.MsgsRate
+		subs = append(subs, st)
+	}
+	engine.subMsgs = tracked
+
+	for _, st := range subs {
+		switch {
+		case delivered > 0:
+			st.Share = st.MsgsRate / delivered
+		case total > 0:
+			st.Share = float64(st.Msgs) / total
+		}
+		st.Pending = int64(st.Share * float64(conn.Pending))
+	}
+	sort.Sort(bySubMsgs(subs))
+
+	return subs
+}
+
+// bySubMsgs sorts subscriptions by the messages delivered, most first.
+type bySubMsgs []*SubTraffic
+
+func (s bySubMsgs) Len() int           { return len(s) }
+func (s bySubMsgs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySubMsgs) Less(i, j int) bool { return s[i].Msgs > s[j].Msgs }
+
+// LeafTraffic is the traffic of a leafnode connection along with its rates.
+type LeafTraffic struct {
+	*LeafInfo
+	Rates *Rates
+}
+
+// leafTraffic measures the rates of each leafnode connection, in the
+// order they were sorted in, comparing them against the previous poll.
+func (engine *Engine) leafTraffic(leafz *Leafz, tdelta time.Duration) []*LeafTraffic {
+	// Leafnodes reconnecting between polls come from another
+	// port, which keeps the rates from being negative.
+	rate := func(val, lastVal int64) float64 {
+		if val < lastVal || tdelta <= 0 {
+			return 0
+		}
+		return float64(val-lastVal) / tdelta.Seconds()
+	}
+	tracked := make(map[string]*LeafInfo)
+	var leafs []*LeafTraffic
+	for _, leaf := range leafz.Leafs {
+		lt := &LeafTraffic{LeafInfo: leaf, Rates: &Rates{}}
+		if last, ok := engine.leafStats[leaf.Addr()]; ok {
+			lt.Rates = &Rates{
+				InMsgsRate:   rate(leaf.InMsgs, last.InMsgs),
+				OutMsgsRate:  rate(leaf.OutMsgs, last.OutMsgs),
+				InBytesRate:  rate(leaf.InBytes, last.InBytes),
+				OutBytesRate: rate(leaf.OutBytes, last.OutBytes),
+			}
+		}
+		tracked[leaf.Addr()] = leaf
+		leafs = append(leafs, lt)
+	}
+	engine.leafStats = tracked
+
+	return leafs
+}
+
+// AccountTraffic is the traffic of an account along with its rates,
+// where the rates in are of the messages published by its clients.
+type AccountTraffic struct {
+	*AccountStat
+	Rates *Rates
+}
+
+// accountTraffic measures the rates of each account with connections,
+// sorted by name or else by the options to sort connections by which
+// apply to them, which /accstatz does not support.
+func (engine *Engine) accountTraffic(accstatz *Accstatz, tdelta time.Duration) []*AccountTraffic {
+	// Accounts losing their connections between polls
+	// are not reported, which would make rates negative.
+	rate := func(val, lastVal int64) float64 {
+		if val < lastVal || tdelta <= 0 {
+			return 0
+		}
+		return float64(val-lastVal) / tdelta.Seconds()
+	}
+	tracked := make(map[string]*AccountStat)
+	var accounts []*AccountTraffic
+	for _, stat := range accstatz.Accounts {
+		at := &AccountTraffic{AccountStat: stat, Rates: &Rates{}}
+		if last, ok := engine.accountStats[stat.Account]; ok {
+			at.Rates = &Rates{
+				InMsgsRate:   rate(stat.Received.Msgs, last.Received.Msgs),
+				OutMsgsRate:  rate(stat.Sent.Msgs, last.Sent.Msgs),
+				InBytesRate:  rate(stat.Received.Bytes, last.Received.Bytes),
+				OutBytesRate: rate(stat.Sent.Bytes, last.Sent.Bytes),
+			}
+		}
+		tracked[stat.Account] = stat
+		accounts = append(accounts, at)
+	}
+	engine.accountStats = tracked
+
+	sort.Sort(byAccountName(accounts))
+	byOpt := &byAccountValue{accounts: accounts}
+	for _, at := range accounts {
+		var value float64
+		switch engine.SortOpt {
+		case "subs":
+			value = float64(at.NumSubs)
+		case "msgs_to":
+			value = float64(at.Sent.Msgs)
+		case "msgs_from":
+			value = float64(at.Received.Msgs)
+		case "bytes_to":
+			value = float64(at.Sent.Bytes)
+		case "bytes_from":
+			value = float64(at.Received.Bytes)
+		default:
+			return accounts
+		}
+		byOpt.values = append(byOpt.values, value)
+	}
+	sort.Stable(byOpt)
+
+	return accounts
+}
+
+// byAccountName sorts accounts by name.
+type byAccountName []*AccountTraffic
+
+func (a byAccountName) Len() int           { return len(a) }
+func (a byAccountName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAccountName) Less(i, j int) bool { return a[i].Account < a[j].Account }
+
+// byAccountValue sorts accounts by a value.
+type byAccountValue struct {
+	accounts []*AccountTraffic
+	values   []float64
+}
+
+func (a *byAccountValue) Len() int           { return len(a.values) }
+func (a *byAccountValue) Less(i, j int) bool { return a.values[i] > a.values[j] }
+func (a *byAccountValue) Swap(i, j int) {
+	a.accounts[i], a.accounts[j] = a.accounts[j], a.accounts[i]
+	a.values[i], a.values[j] = a.values[j], a.values[i]
+}
+
+// byGatewayName sorts gateways by name.
+type byGatewayName []*GatewayTraffic
+
+func (g byGatewayName) Len() int           { return len(g) }
+func (g byGatewayName) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g byGatewayName) Less(i, j int) bool { return g[i].Name < g[j].Name }
+
+// SubjectSubs are the subscriptions to a subject,
+// along with the messages delivered to them.
+type SubjectSubs struct {
+	Subject   string
+	Subs      int
+	QueueSubs int
+	Msgs      int64
+}
+
+// RTTBuckets are the upper bounds of the buckets the round trip times
+// of the connections are counted in, with one more for those above.
+var RTTBuckets = []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond}
+
+// CountRTTs counts the polled connections in each of the RTTBuckets,
+// along with the connections above the last one. Connections which
+// did not report their round trip time yet are not counted.
+func CountRTTs(conns []ExtConnInfo) []int {
+	counts := make([]int, len(RTTBuckets)+1)
+	for _, conn := range conns {
+		rtt, err := time.ParseDuration(conn.RTT)
+		if err != nil {
+			continue
+		}
+		i := 0
+		for i < len(RTTBuckets) && rtt >= RTTBuckets[i] {
+			i++
+		}
+		counts[i]++
+	}
+	return counts
+}
+
+// CountSubjectSubs groups the subscriptions listed by the server
+// by subject, sorted by their number of subscriptions.
+func CountSubjectSubs(subs []SubDetails) []*SubjectSubs {
+	bySubject := make(map[string]*SubjectSubs)
+	var subjects []*SubjectSubs
+	for _, sub := range subs {
+		ss, ok := bySubject[sub.Subject]
+		if !ok {
+			ss = &SubjectSubs{Subject: sub.Subject}
+			bySubject[sub.Subject] = ss
+			subjects = append(subjects, ss)
+		}
+		ss.Subs++
+		if sub.Queue != "" {
+			ss.QueueSubs++
+		}
+		ss.Msgs += sub.Msgs
+	}
+
+	sort.Sort(bySubjectSubs(subjects))
+
+	return subjects
+}
+
+// bySubjectSubs sorts subjects by number of subscriptions, then by name.
+type bySubjectSubs []*SubjectSubs
+
+func (s bySubjectSubs) Len() int      { return len(s) }
+func (s bySubjectSubs) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySubjectSubs) Less(i, j int) bool {
+	if s[i].Subs != s[j].Subs {
+		return s[i].Subs > s[j].Subs
+	}
+	return s[i].Subject < s[j].Subject
+}
+
+// countAccountConns groups the polled connections by account
+// and compares the counts against the previous poll.
+func (engine *Engine) countAccountConns(extConnz *ExtConnz) []*AccountConns {
+	counts := make(map[string]int)
+	for _, conn := range extConnz.Conns {
+		counts[conn.Account]++
+	}
+
+	var accounts []*AccountConns
+	for account, n := range counts {
+		accounts = append(accounts, &AccountConns{
+			Account: account,
+			Conns:   n,
+			Delta:   n - engine.accountConns[account],
+		})
+	}
+
+	// Accounts whose connections are all gone
+	for account, n := range engine.accountConns {
+		if _, ok := counts[account]; !ok {
+			accounts = append(accounts, &AccountConns{Account: account, Delta: -n})
+		}
+	}
+	engine.accountConns = counts
+
+	sort.Sort(byAccountConns(accounts))
+
+	return accounts
+}
+
+// byAccountConns sorts accounts by number of connections, then by name.
+type byAccountConns []*AccountConns
+
+func (a byAccountConns) Len() int      { return len(a) }
+func (a byAccountConns) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAccountConns) Less(i, j int) bool {
+	if a[i].Conns != a[j].Conns {
+		return a[i].Conns > a[j].Conns
+	}
+	return a[i].Account < a[j].Account
+}
+
+// ParseCIDRs parses a comma separated list of subnets in CIDR notation.
+func ParseCIDRs(cidrs string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// IPInNets reports whether an ip belongs to any of the subnets.
+func IPInNets(ip string, nets []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterConns removes the polled connections which are not kept,
+// along with their fields from newer servers.
+func filterConns(connz *gnatsd.Connz, extConnz *ExtConnz, keep func(*gnatsd.ConnInfo, *ExtConnInfo) bool) {
+	if extConnz != nil && len(extConnz.Conns) != len(connz.Conns) {
+		extConnz = nil
+	}
+
+	var conns []gnatsd.ConnInfo
+	var extConns []ExtConnInfo
+	for i := range connz.Conns {
+		var ext *ExtConnInfo
+		if extConnz != nil {
+			ext = &extConnz.Conns[i]
+		}
+		if !keep(&connz.Conns[i], ext) {
+			continue
+		}
+		conns = append(conns, connz.Conns[i])
+		if ext != nil {
+			extConns = append(extConns, *ext)
+		}
+	}
+
+	connz.Conns = conns
+	connz.NumConns = len(conns)
+	if extConnz != nil {
+		extConnz.Conns = extConns
+	}
+}
+
+// IdleTime returns how long a connection has been idle, as reported
+// by the server or otherwise measured since its last activity.
+func IdleTime(conn *gnatsd.ConnInfo, now time.Time) (time.Duration, bool) {
+	if idle, err := ParseUptime(conn.Idle); err == nil {
+		return idle, true
+	}
+	if !conn.LastActivity.IsZero() {
+		return now.Sub(conn.LastActivity), true
+	}
+	return 0, false
+}
+
+// Options for sorting connections which are not known by the vendored
+// gnatsd, so that connections are sorted once they have been polled.
+const (
+	SortByRTT gnatsd.SortOpt = "rtt"
+	SortByTLS gnatsd.SortOpt = "tls"
+	SortByLag gnatsd.SortOpt = "lag"
+)
+
+// SortOpts are the options to sort the connections by.
+var SortOpts = []gnatsd.SortOpt{
+	"cid", "subs", "pending", "msgs_to", "msgs_from", "bytes_to", "bytes_from",
+	"last", "idle", "uptime", SortByRTT, SortByTLS, SortByLag,
+}
+
+// SortOptsList returns the options to sort by as a comma separated list.
+func SortOptsList() string {
+	opts := make([]string, len(SortOpts))
+	for i, opt := range SortOpts {
+		opts[i] = string(opt)
+	}
+	return strings.Join(opts, ", ")
+}
+
+// IsValidSortOpt reports whether connections can be sorted by the option.
+func IsValidSortOpt(opt gnatsd.SortOpt) bool {
+	return opt.IsValid() || opt == SortByRTT || opt == SortByTLS || opt == SortByLag
+}
+
+// sortConns sorts the polled connections by the options which
+// are not known by the vendored gnatsd, highest values first.
+func sortConns(opt gnatsd.SortOpt, connz *gnatsd.Connz, extConnz *ExtConnz) {
+	if opt != SortByRTT && opt != SortByTLS {
+		return
+	}
+	if extConnz != nil && len(extConnz.Conns) != len(connz.Conns) {
+		extConnz = nil
+	}
+
+	conns := &byValue{connz: connz, extConnz: extConnz}
+	for i, conn := range connz.Conns {
+		var value float64
+		switch opt {
+		case SortByRTT:
+			// Servers sort connections without RTT first,
+			// though these are better left at the end.
+			value = -1
+			if extConnz != nil {
+				if rtt, err := time.ParseDuration(extConnz.Conns[i].RTT); err == nil {
+					value = float64(rtt)
+				}
+			}
+		case SortByTLS:
+			// Plain connections first so that they stand out
+			if conn.TLSVersion == "" {
+				value = 1
+			}
+		}
+		conns.values = append(conns.values, value)
+	}
+	sort.Stable(conns)
+}
+
+// sortLeafs sorts the leafnode connections by the options to sort
+// connections by which apply to them, which /leafz does not support.
+// Leafnodes are left in the order of the server for the rest.
+func sortLeafs(opt gnatsd.SortOpt, leafs []*LeafInfo) {
+	byOpt := &byLeafValue{leafs: leafs}
+	for _, leaf := range leafs {
+		var value float64
+		switch opt {
+		case "subs":
+			value = float64(leaf.NumSubs)
+		case "msgs_to":
+			value = float64(leaf.OutMsgs)
+		case "msgs_from":
+			value = float64(leaf.InMsgs)
+		case "bytes_to":
+			value = float64(leaf.OutBytes)
+		case "bytes_from":
+			value = float64(leaf.InBytes)
+		case SortByRTT:
+			value = -1
+			if rtt, err := time.ParseDuration(leaf.RTT); err == nil {
+				value = float64(rtt)
+			}
+		default:
+			return
+		}
+		byOpt.values = append(byOpt.values, value)
+	}
+	sort.Stable(byOpt)
+}
+
+// sortConsumers sorts the consumers of a stream by their lag, the
+// messages left to deliver, or by their pending acknowledgements.
+// Consumers are left in the order of the server for the rest.
+func sortConsumers(opt gnatsd.SortOpt, consumers []*ConsumerInfo) {
+	byOpt := &byConsumerValue{consumers: consumers}
+	for _, consumer := range consumers {
+		var value float64
+		switch opt {
+		case SortByLag:
+			value = float64(consumer.NumPending)
+		case "pending":
+			value = float64(consumer.NumAckPending)
+		default:
+			return
+		}
+		byOpt.values = append(byOpt.values, value)
+	}
+	sort.Stable(byOpt)
+}
+
+// byConsumerValue sorts consumers by a value.
+type byConsumerValue struct {
+	consumers []*ConsumerInfo
+	values    []float64
+}
+
+func (c *byConsumerValue) Len() int           { return len(c.values) }
+func (c *byConsumerValue) Less(i, j int) bool { return c.values[i] > c.values[j] }
+func (c *byConsumerValue) Swap(i, j int) {
+	c.consumers[i], c.consumers[j] = c.consumers[j], c.consumers[i]
+	c.values[i], c.values[j] = c.values[j], c.values[i]
+}
+
+// byLeafValue sorts leafnode connections by a value.
+type byLeafValue struct {
+	leafs  []*LeafInfo
+	values []float64
+}
+
+func (l *byLeafValue) Len() int           { return len(l.values) }
+func (l *byLeafValue) Less(i, j int) bool { return l.values[i] > l.values[j] }
+func (l *byLeafValue) Swap(i, j int) {
+	l.leafs[i], l.leafs[j] = l.leafs[j], l.leafs[i]
+	l.values[i], l.values[j] = l.values[j], l.values[i]
+}
+
+// byValue sorts connections along with their fields from
+// newer servers, which are in the same order, by a value.
+type byValue struct {
+	connz    *gnatsd.Connz
+	extConnz *ExtConnz
+	values   []float64
+}
+
+func (c *byValue) Len() int           { return len(c.values) }
+func (c *byValue) Less(i, j int) bool { return c.values[i] > c.values[j] }
+func (c *byValue) Swap(i, j int) {
+	c.connz.Conns[i], c.connz.Conns[j] = c.connz.Conns[j], c.connz.Conns[i]
+	if c.extConnz != nil {
+		c.extConnz.Conns[i], c.extConnz.Conns[j] = c.extConnz.Conns[j], c.extConnz.Conns[i]
+	}
+	c.values[i], c.values[j] = c.values[j], c.values[i]
+}
+
+// UserConns aggregates the polled connections which
+// authenticated as the same user of an account.
+type UserConns struct {
+	Account  string
+	User     string
+	Conns    int
+	Subs     uint32
+	Pending  int
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+	Rates    *Rates
+}
+
+type userKey struct {
+	account string
+	user    string
+}
+
+// groupUserConns groups the polled connections by account and user,
+// with the rates of each group since the previous poll.
+func (engine *Engine) groupUserConns(connz *gnatsd.Connz, extConnz *ExtConnz, tdelta time.Duration) []*UserConns {
+	if extConnz != nil && len(extConnz.Conns) != len(connz.Conns) {
+		extConnz = nil
+	}
+
+	groups := make(map[userKey]*UserConns)
+	var users []*UserConns
+	for i, conn := range connz.Conns {
+		key := userKey{user: conn.AuthorizedUser}
+		if extConnz != nil {
+			key.account = extConnz.Conns[i].Account
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = &UserConns{Account: key.account, User: key.user, Rates: &Rates{}}
+			groups[key] = group
+			users = append(users, group)
+		}
+		group.Conns++
+		group.Subs += conn.NumSubs
+		group.Pending += conn.Pending
+		group.InMsgs += conn.InMsgs
+		group.OutMsgs += conn.OutMsgs
+		group.InBytes += conn.InBytes
+		group.OutBytes += conn.OutBytes
+	}
+
+	// Connections closing between polls would make the rates negative
+	rate := func(val, lastVal int64) float64 {
+		if val < lastVal || tdelta <= 0 {
+			return 0
+		}
+		return float64(val-lastVal) / tdelta.Seconds()
+	}
+	for key, group := range groups {
+		if last, ok := engine.userConns[key]; ok {
+			group.Rates = &Rates{
+				InMsgsRate:   rate(group.InMsgs, last.InMsgs),
+				OutMsgsRate:  rate(group.OutMsgs, last.OutMsgs),
+				InBytesRate:  rate(group.InBytes, last.InBytes),
+				OutBytesRate: rate(group.OutBytes, last.OutBytes),
+			}
+		}
+	}
+	engine.userConns = groups
+
+	sort.Sort(byUserConns(users))
+
+	return users
+}
+
+// byUserConns sorts users by number of connections, then by account and name.
+type byUserConns []*UserConns
+
+func (u byUserConns) Len() int      { return len(u) }
+func (u byUserConns) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+func (u byUserConns) Less(i, j int) bool {
+	if u[i].Conns != u[j].Conns {
+		return u[i].Conns > u[j].Conns
+	}
+	if u[i].Account != u[j].Account {
+		return u[i].Account < u[j].Account
+	}
+	return u[i].User < u[j].User
+}
+
+// RateInterval returns the interval over which the counters of two polls
+// are compared, preferring the one between the times reported by the
+// server since the counters were sampled then. It falls back to the
+// interval measured locally, which uses the monotonic clock, when the
+// server did not report its time or when both disagree by more than
+// half, which happens when either clock is adjusted between polls.
+func RateInterval(server, local time.Duration) time.Duration {
+	if server <= 0 || server > local*3/2 || server < local/2 {
+		return local
+	}
+	return server
+}
+
+// ConnzTotals returns the sum of the in/out msgs and bytes
+// of the connections in a connz response.
+func ConnzTotals(connz *gnatsd.Connz) (inMsgs, outMsgs, inBytes, outBytes int64) {
+	for _, conn := range connz.Conns {
+		inMsgs += conn.InMsgs
+		outMsgs += conn.OutMsgs
+		inBytes += conn.InBytes
+		outBytes += conn.OutBytes
+	}
+	return
+}
+
+// SubjectMatches reports whether a subject matches a filter,
+// which can use the '*' and '>' wildcards like NATS subscriptions.
+func SubjectMatches(filter, subject string) bool {
+	if filter == "" {
+		return true
+	}
+
+	ftokens := strings.Split(filter, ".")
+	stokens := strings.Split(subject, ".")
+	for i, token := range ftokens {
+		if token == ">" {
+			return len(stokens) > i
+		}
+		if i >= len(stokens) {
+			return false
+		}
+		if token != "*" && token != stokens[i] {
+			return false
+		}
+	}
+
+	return len(ftokens) == len(stokens)
+}
+
+// AlertHistorySize is the number of the latest alert events
+// kept by the session.
+const AlertHistorySize = 100
+
+// Session keeps track of what has been observed from a NATS server
+// while nats-top is running, so that it can be summarized on exit.
+type Session struct {
+	sync.Mutex
+
+	Start         time.Time
+	Samples       int
+	MaxConns      int
+	SlowConsumers int64
+	Alerts        int
+	InMsgsRate    RateSummary
+	OutMsgsRate   RateSummary
+	InBytesRate   RateSummary
+	OutBytesRate  RateSummary
+
+	lastSlowConsumers int64
+	events            []*AlertEvent
+}
+
+// RateSummary holds the min, max and average of a tracked rate.
+type RateSummary struct {
+	Min   float64
+	Max   float64
+	Sum   float64
+	Count int
+}
+
+// NewSession returns a Session starting now.
+func NewSession() *Session {
+	return &Session{Start: time.Now()}
+}
+
+// Add records a rate sample.
+func (r *RateSummary) Add(v float64) {
+	if r.Count == 0 {
+		r.Min = v
+		r.Max = v
+	} else {
+		r.Min = math.Min(r.Min, v)
+		r.Max = math.Max(r.Max, v)
+	}
+	r.Sum += v
+	r.Count++
+}
+
+// Avg returns the average of the recorded samples.
+func (r *RateSummary) Avg() float64 {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.Sum / float64(r.Count)
+}
+
+// Update records the latest polled stats into the session.
+func (s *Session) Update(stats *Stats) {
+	s.Lock()
+	defer s.Unlock()
+
+	if stats.Varz == nil || stats.Connz == nil || stats.Rates == nil {
+		return
+	}
+
+	if stats.Connz.NumConns > s.MaxConns {
+		s.MaxConns = stats.Connz.NumConns
+	}
+
+	// Rates are not available until the second sample and the
+	// slow consumers counter is cumulative, so use the first
+	// sample only as the baseline, as well as the first one
+	// after measuring again from scratch.
+	if s.Samples > 0 && !stats.Baseline {
+		s.InMsgsRate.Add(stats.Rates.InMsgsRate)
+		s.OutMsgsRate.Add(stats.Rates.OutMsgsRate)
+		s.InBytesRate.Add(stats.Rates.InBytesRate)
+		s.OutBytesRate.Add(stats.Rates.OutBytesRate)
+
+		if delta := stats.Varz.SlowConsumers - s.lastSlowConsumers; delta > 0 {
+			s.SlowConsumers += delta
+		}
+	}
+	s.lastSlowConsumers = stats.Varz.SlowConsumers
+	s.Samples++
+
+	for _, event := range stats.AlertEvents {
+		if !event.Resolved {
+			s.Alerts++
+		}
+	}
+	s.events = append(s.events, stats.AlertEvents...)
+	if len(s.events) > AlertHistorySize {
+		s.events = s.events[len(s.events)-AlertHistorySize:]
+	}
+}
+
+// AlertHistory returns the latest alert events of the session,
+// the most recent first.
+func (s *Session) AlertHistory() []*AlertEvent {
+	s.Lock()
+	defer s.Unlock()
+
+	events := make([]*AlertEvent, len(s.events))
+	for i, event := range s.events {
+		events[len(s.events)-1-i] = event
+	}
+	return events
+}
+
+// Summary returns a report of the session.
+func (s *Session) Summary() string {
+	s.Lock()
+	defer s.Unlock()
+
+	text := "nats-top session summary\n"
+	text += fmt.Sprintf("  Duration: %s  Samples: %d\n", time.Since(s.Start)/time.Second*time.Second, s.Samples)
+	text += fmt.Sprintf("  Peak Connections: %d  Slow Consumers: %d  Alerts: %d\n", s.MaxConns, s.SlowConsumers, s.Alerts)
+
+	rates := []struct {
+		name string
+		r    *RateSummary
+	}{
+		{"In Msgs/Sec:   ", &s.InMsgsRate},
+		{"Out Msgs/Sec:  ", &s.OutMsgsRate},
+		{"In Bytes/Sec:  ", &s.InBytesRate},
+		{"Out Bytes/Sec: ", &s.OutBytesRate},
+	}
+	for i, rate := range rates {
+		r := rate.r
+		if i < 2 {
+			text += fmt.Sprintf("  %s min %.1f  max %.1f  avg %.1f\n", rate.name, r.Min, r.Max, r.Avg())
+		} else {
+			text += fmt.Sprintf("  %s min %s  max %s  avg %s\n", rate.name,
+				Psize(int64(r.Min)), Psize(int64(r.Max)), Psize(int64(r.Avg())))
+		}
+	}
+
+	return text
 }
 
 // Psize takes a float and returns a human readable string.
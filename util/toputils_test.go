@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -152,6 +155,178 @@ func TestMonitorStats(t *testing.T) {
 	}
 }
 
+func TestMonitorStatsRefresh(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 60)
+	engine.SetupHTTP()
+	s := runMonitorServer(server.DEFAULT_HTTP_PORT)
+	defer s.Shutdown()
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	engine.Refresh()
+	select {
+	case stats := <-engine.StatsCh:
+		if stats.Varz.Cores < 1 {
+			t.Fatalf("Could not monitor number of cores. got: %v", stats.Error)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the refresh")
+	}
+}
+
+func TestMonitorStatsFailover(t *testing.T) {
+	engine := NewEngine("", 0, 10, 1)
+	engine.SetupHTTP()
+	live := fmt.Sprintf("127.0.0.1:%d", server.DEFAULT_HTTP_PORT)
+	err := engine.SetupServers([]string{"127.0.0.1:11499", live}, false)
+	if err != nil {
+		t.Fatalf("Expected to set up servers. Got: %s", err)
+	}
+	if engine.Uri != "http://127.0.0.1:11499" {
+		t.Fatalf("Expected to poll the first server. got: %s", engine.Uri)
+	}
+	s := runMonitorServer(server.DEFAULT_HTTP_PORT)
+	defer s.Shutdown()
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	for _, failed := range []bool{true, false} {
+		select {
+		case stats := <-engine.StatsCh:
+			if failed && stats.Error.Error() == "" {
+				t.Fatalf("Expected polling the first server to fail")
+			}
+			if !failed && stats.Varz.Cores < 1 {
+				t.Fatalf("Expected polling to recover after failing over, got: %v", stats.Error)
+			}
+			if !strings.Contains(stats.Failover, "to http://"+live) {
+				t.Fatalf("Expected failover to be noted, got: %q", stats.Failover)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out polling /varz via http")
+		}
+	}
+
+	// Without other servers there is nothing to fail over to
+	engine = NewEngine("127.0.0.1", 11499, 10, 1)
+	engine.SetupHTTP()
+	if engine.Failover() {
+		t.Fatalf("Expected no failover without servers")
+	}
+}
+
+func TestAccountFilterURIs(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+	if uri := engine.subszURI(); uri != engine.Uri+"/subsz" {
+		t.Fatalf("Expected no query without filter, got: %s", uri)
+	}
+
+	// Subscriptions and streams are those of the filtered account
+	engine.Account = "A B"
+	engine.ListSubjects = true
+	if uri := engine.subszURI(); uri != engine.Uri+"/subsz?acc=A+B&limit=10&subs=1" {
+		t.Fatalf("Unexpected subsz uri: %s", uri)
+	}
+	if uri := engine.jszURI(); uri != engine.Uri+"/jsz?acc=A+B" {
+		t.Fatalf("Unexpected jsz uri: %s", uri)
+	}
+	opts := sysOptions("JSZ", url.Values{"acc": {"A B"}})
+	if opts["account"] != "A B" {
+		t.Fatalf("Expected the account option over NATS, got: %+v", opts)
+	}
+}
+
+func TestSetViewOptionsWhilePolling(t *testing.T) {
+	paths := make(chan string, 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case paths <- r.URL.RequestURI():
+		default:
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.SetupHTTP()
+	if err := engine.SetupServers([]string{ts.URL}, false); err != nil {
+		t.Fatalf("Expected to set up servers. Got: %s", err)
+	}
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+	<-engine.StatsCh
+
+	// Listing the subjects polls them from the next poll on
+	engine.SetViewOptions(ViewOptions{ListSubjects: true})
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case path := <-paths:
+			if path == "/subsz?limit=10&subs=1" {
+				return
+			}
+		case <-engine.StatsCh:
+		case <-timeout:
+			t.Fatalf("Timed out polling the subjects")
+		}
+	}
+}
+
+func TestSwitchCluster(t *testing.T) {
+	auths := make(chan string, 100)
+	newServer := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auths <- r.Host + " " + r.Header.Get("Authorization")
+			fmt.Fprint(w, "{}")
+		}))
+	}
+	east, west := newServer(), newServer()
+	defer east.Close()
+	defer west.Close()
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.SetupHTTP()
+	engine.Username, engine.Password = "flag", "pass"
+	if err := engine.SetupCluster(&Cluster{Name: "west", Servers: []string{west.URL}}); err != nil {
+		t.Fatalf("Expected to set up cluster. Got: %s", err)
+	}
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	// Switching is applied by the polling goroutine, with the
+	// credentials of the cluster or else those of the flags
+	for _, tc := range []struct {
+		cluster *Cluster
+		host    string
+		auth    string
+	}{
+		{&Cluster{Name: "east", Servers: []string{east.URL}, Token: "s3cr3t"}, east.Listener.Addr().String(), "Bearer s3cr3t"},
+		{&Cluster{Name: "west", Servers: []string{west.URL}}, west.Listener.Addr().String(), "Basic ZmxhZzpwYXNz"},
+	} {
+		if err := engine.SwitchCluster(tc.cluster); err != nil {
+			t.Fatalf("Expected to switch to %s. Got: %s", tc.cluster.Name, err)
+		}
+		timeout := time.After(3 * time.Second)
+		for switched := false; !switched; {
+			select {
+			case stats := <-engine.StatsCh:
+				switched = stats.Options.Cluster == tc.cluster.Name && stats.Options.Server == tc.cluster.Servers[0]
+			case <-timeout:
+				t.Fatalf("Timed out switching to %s", tc.cluster.Name)
+			}
+		}
+		for len(auths) > 1 {
+			<-auths
+		}
+		if auth := <-auths; auth != tc.host+" "+tc.auth {
+			t.Fatalf("Expected %s to be polled with %q, got: %q", tc.cluster.Name, tc.auth, auth)
+		}
+	}
+}
+
 func TestMonitoringTLSConnectionUsingRootCA(t *testing.T) {
 	srv, _ := gnatsd.RunServerWithConfig("./test/tls.conf")
 	defer srv.Shutdown()
@@ -241,3 +416,651 @@ func TestMonitoringTLSConnectionUsingCertsAndInsecure(t *testing.T) {
 		t.Fatalf("Timed out polling /varz via https")
 	}
 }
+
+func TestSetupHTTPSWithInvalidCACert(t *testing.T) {
+	engine := NewEngine("127.0.0.1", 8223, 10, 1)
+	err := engine.SetupHTTPS("./test/tls.conf", "", "", false)
+	if err == nil || !strings.Contains(err.Error(), "no certificates found") {
+		t.Fatalf("Expected an error on a CA cert without certificates. Got: %v", err)
+	}
+}
+
+func TestSessionAlertHistory(t *testing.T) {
+	session := NewSession()
+
+	for i := 0; i < AlertHistorySize+10; i++ {
+		session.Update(&Stats{
+			Varz:        &server.Varz{},
+			Connz:       &server.Connz{},
+			Rates:       &Rates{},
+			AlertEvents: []*AlertEvent{{Alert: &Alert{Target: fmt.Sprintf("%d", i)}}},
+		})
+	}
+
+	events := session.AlertHistory()
+	if len(events) != AlertHistorySize {
+		t.Fatalf("Wrong number of alert events. expected: %v, got: %v", AlertHistorySize, len(events))
+	}
+	if first, last := events[0].Alert.Target, events[len(events)-1].Alert.Target; first != "109" || last != "10" {
+		t.Fatalf("Wrong alert events. expected: 109 to 10, got: %s to %s", first, last)
+	}
+}
+
+func TestSessionSummary(t *testing.T) {
+	session := NewSession()
+
+	samples := []struct {
+		conns int
+		slow  int64
+		rate  float64
+	}{
+		{5, 2, 0},
+		{10, 2, 100},
+		{7, 4, 300},
+		{3, 5, 200},
+	}
+	for _, sample := range samples {
+		session.Update(&Stats{
+			Varz:  &server.Varz{SlowConsumers: sample.slow},
+			Connz: &server.Connz{NumConns: sample.conns},
+			Rates: &Rates{InMsgsRate: sample.rate},
+		})
+	}
+
+	if session.Samples != 4 {
+		t.Fatalf("Wrong number of samples. expected: %v, got: %v", 4, session.Samples)
+	}
+
+	if session.MaxConns != 10 {
+		t.Fatalf("Wrong peak connections. expected: %v, got: %v", 10, session.MaxConns)
+	}
+
+	if session.SlowConsumers != 3 {
+		t.Fatalf("Wrong slow consumers during session. expected: %v, got: %v", 3, session.SlowConsumers)
+	}
+
+	r := session.InMsgsRate
+	if r.Min != 100 || r.Max != 300 || r.Avg() != 200 {
+		t.Fatalf("Wrong rate summary. expected: min 100 max 300 avg 200, got: min %v max %v avg %v", r.Min, r.Max, r.Avg())
+	}
+}
+
+func TestSessionSummaryAfterReset(t *testing.T) {
+	session := NewSession()
+
+	samples := []struct {
+		slow     int64
+		rate     float64
+		baseline bool
+	}{
+		{2, 0, true},
+		{2, 100, false},
+		{3, 300, false},
+		// Failed over to a server with other counters
+		{10, 0, true},
+		{10, 200, false},
+	}
+	for _, sample := range samples {
+		session.Update(&Stats{
+			Varz:     &server.Varz{SlowConsumers: sample.slow},
+			Connz:    &server.Connz{},
+			Rates:    &Rates{InMsgsRate: sample.rate},
+			Baseline: sample.baseline,
+		})
+	}
+
+	if session.SlowConsumers != 1 {
+		t.Fatalf("Wrong slow consumers during session. expected: %v, got: %v", 1, session.SlowConsumers)
+	}
+
+	r := session.InMsgsRate
+	if r.Count != 3 || r.Min != 100 || r.Max != 300 || r.Avg() != 200 {
+		t.Fatalf("Wrong rate summary. expected: 3 samples min 100 max 300 avg 200, got: %v samples min %v max %v avg %v",
+			r.Count, r.Min, r.Max, r.Avg())
+	}
+}
+
+func TestSubjectMatches(t *testing.T) {
+	tests := []struct {
+		filter   string
+		subject  string
+		expected bool
+	}{
+		{"", "foo.bar", true},
+		{"foo.bar", "foo.bar", true},
+		{"foo.bar", "foo.baz", false},
+		{"foo", "foo.bar", false},
+		{"foo.*", "foo.bar", true},
+		{"foo.*", "foo.bar.baz", false},
+		{"*.bar", "foo.bar", true},
+		{"foo.>", "foo.bar.baz", true},
+		{"foo.>", "foo", false},
+		{">", "foo", true},
+		{"foo.*.baz", "foo.bar.baz", true},
+		{"foo.*.baz", "foo.bar.qux", false},
+	}
+	for _, test := range tests {
+		got := SubjectMatches(test.filter, test.subject)
+		if got != test.expected {
+			t.Fatalf("Wrong match of %q against %q. expected: %v, got: %v", test.subject, test.filter, test.expected, got)
+		}
+	}
+}
+
+func TestCountAccountConns(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	extConnz := func(accounts ...string) *ExtConnz {
+		ec := &ExtConnz{}
+		for _, account := range accounts {
+			ec.Conns = append(ec.Conns, ExtConnInfo{Account: account})
+		}
+		return ec
+	}
+
+	accounts := engine.countAccountConns(extConnz("A", "B", "A"))
+	if len(accounts) != 2 || accounts[0].Account != "A" || accounts[0].Conns != 2 || accounts[0].Delta != 2 {
+		t.Fatalf("Wrong account connections. got: %+v", accounts[0])
+	}
+
+	accounts = engine.countAccountConns(extConnz("A", "C", "C", "C"))
+	expected := []AccountConns{{"C", 3, 3}, {"A", 1, -1}, {"B", 0, -1}}
+	if len(accounts) != len(expected) {
+		t.Fatalf("Wrong number of accounts. expected: %v, got: %v", len(expected), len(accounts))
+	}
+	for i, acc := range accounts {
+		if *acc != expected[i] {
+			t.Fatalf("Wrong account connections. expected: %+v, got: %+v", expected[i], *acc)
+		}
+	}
+}
+
+func TestSortByRTT(t *testing.T) {
+	connz := &server.Connz{Conns: []server.ConnInfo{{Cid: 1}, {Cid: 2}, {Cid: 3}, {Cid: 4}}}
+	extConnz := &ExtConnz{Conns: []ExtConnInfo{{RTT: ""}, {RTT: "1.5ms"}, {RTT: "250µs"}, {RTT: "2s"}}}
+
+	sortConns(SortByRTT, connz, extConnz)
+
+	expected := []uint64{4, 2, 3, 1}
+	for i, cid := range expected {
+		if connz.Conns[i].Cid != cid {
+			t.Fatalf("Wrong connection at %d. expected: %d, got: %d", i, cid, connz.Conns[i].Cid)
+		}
+	}
+	if extConnz.Conns[0].RTT != "2s" || extConnz.Conns[3].RTT != "" {
+		t.Fatalf("Expected extended connection info to be sorted along, got: %+v", extConnz.Conns)
+	}
+
+	if !IsValidSortOpt(SortByRTT) || !IsValidSortOpt(SortByTLS) || !IsValidSortOpt("subs") || IsValidSortOpt("latency") {
+		t.Fatalf("Wrong validation of sort options")
+	}
+}
+
+func TestSortByTLS(t *testing.T) {
+	connz := &server.Connz{Conns: []server.ConnInfo{
+		{Cid: 1, TLSVersion: "1.2"},
+		{Cid: 2},
+		{Cid: 3, TLSVersion: "1.2"},
+		{Cid: 4},
+	}}
+
+	sortConns(SortByTLS, connz, nil)
+
+	expected := []uint64{2, 4, 1, 3}
+	for i, cid := range expected {
+		if connz.Conns[i].Cid != cid {
+			t.Fatalf("Wrong connection at %d. expected: %d, got: %d", i, cid, connz.Conns[i].Cid)
+		}
+	}
+}
+
+func TestConnKind(t *testing.T) {
+	tests := []struct {
+		conn     ExtConnInfo
+		expected string
+	}{
+		{ExtConnInfo{}, ""},
+		{ExtConnInfo{Kind: "Client", Type: "nats"}, "CLIENT"},
+		{ExtConnInfo{Kind: "Client", Type: "mqtt"}, "MQTT"},
+		{ExtConnInfo{Kind: "Client", Type: "websocket"}, "WS"},
+		{ExtConnInfo{Kind: "Router"}, "ROUTER"},
+		{ExtConnInfo{Kind: "Leafnode"}, "LEAF"},
+		{ExtConnInfo{Kind: "JetStream"}, "JETSTREAM"},
+	}
+	for _, test := range tests {
+		got := ConnKind(&test.conn)
+		if got != test.expected {
+			t.Fatalf("Wrong kind for %+v. expected: %q, got: %q", test.conn, test.expected, got)
+		}
+	}
+}
+
+func TestFilterConns(t *testing.T) {
+	connz := &server.Connz{NumConns: 3, Conns: []server.ConnInfo{
+		{Cid: 1, AuthorizedUser: "a"},
+		{Cid: 2, AuthorizedUser: "b"},
+		{Cid: 3, AuthorizedUser: "a"},
+	}}
+	extConnz := &ExtConnz{Conns: []ExtConnInfo{{Account: "A1"}, {Account: "B"}, {Account: "A3"}}}
+
+	filterConns(connz, extConnz, func(conn *server.ConnInfo, _ *ExtConnInfo) bool {
+		return conn.AuthorizedUser == "a"
+	})
+
+	if connz.NumConns != 2 || len(connz.Conns) != 2 || len(extConnz.Conns) != 2 {
+		t.Fatalf("Wrong number of connections. expected: 2, got: %d", connz.NumConns)
+	}
+	if connz.Conns[1].Cid != 3 || extConnz.Conns[1].Account != "A3" {
+		t.Fatalf("Expected connections to stay aligned, got: %+v, %+v", connz.Conns, extConnz.Conns)
+	}
+}
+
+func TestGroupUserConns(t *testing.T) {
+	engine := NewEngine("127.0.0.1", 8222, 1024, 1)
+
+	connz := &server.Connz{Conns: []server.ConnInfo{
+		{Cid: 1, AuthorizedUser: "a", NumSubs: 1, InMsgs: 10},
+		{Cid: 2, AuthorizedUser: "b", NumSubs: 2, InMsgs: 5},
+		{Cid: 3, AuthorizedUser: "a", NumSubs: 3, InMsgs: 20},
+		{Cid: 4, AuthorizedUser: "a", NumSubs: 4, InMsgs: 1},
+	}}
+	extConnz := &ExtConnz{Conns: []ExtConnInfo{{Account: "A"}, {Account: "A"}, {Account: "A"}, {Account: "B"}}}
+
+	users := engine.groupUserConns(connz, extConnz, time.Second)
+	expected := []struct {
+		account string
+		user    string
+		conns   int
+		subs    uint32
+	}{
+		{"A", "a", 2, 4},
+		{"A", "b", 1, 2},
+		{"B", "a", 1, 4},
+	}
+	if len(users) != len(expected) {
+		t.Fatalf("Wrong number of users. expected: %d, got: %d", len(expected), len(users))
+	}
+	for i, e := range expected {
+		u := users[i]
+		if u.Account != e.account || u.User != e.user || u.Conns != e.conns || u.Subs != e.subs {
+			t.Fatalf("Wrong user. expected: %+v, got: %+v", e, u)
+		}
+		if u.Rates.InMsgsRate != 0 {
+			t.Fatalf("Expected no rates on first poll, got: %v", u.Rates.InMsgsRate)
+		}
+	}
+
+	// Rates are computed from the totals of the previous poll
+	connz.Conns[0].InMsgs += 10
+	connz.Conns[2].InMsgs += 10
+	connz.Conns = connz.Conns[:3]
+	extConnz.Conns = extConnz.Conns[:3]
+	users = engine.groupUserConns(connz, extConnz, 2*time.Second)
+	if users[0].Rates.InMsgsRate != 10 {
+		t.Fatalf("Wrong rate. expected: %v, got: %v", 10.0, users[0].Rates.InMsgsRate)
+	}
+	if len(users) != 2 {
+		t.Fatalf("Expected users without connections to be removed, got: %d", len(users))
+	}
+}
+
+func TestIPInNets(t *testing.T) {
+	nets, err := ParseCIDRs("10.42.0.0/16, 192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("Expected to parse subnets. Got: %s", err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"10.42.3.4":   true,
+		"10.43.3.4":   false,
+		"192.168.1.9": true,
+		"::1":         false,
+		"invalid":     false,
+	} {
+		if got := IPInNets(ip, nets); got != expected {
+			t.Fatalf("Wrong match for %s. expected: %v, got: %v", ip, expected, got)
+		}
+	}
+
+	if _, err := ParseCIDRs("10.42.0.0"); err == nil {
+		t.Fatalf("Expected error parsing subnet without mask")
+	}
+}
+
+func TestMark(t *testing.T) {
+	varz := &server.Varz{InMsgs: 10, OutMsgs: 20, InBytes: 100, OutBytes: 200}
+	connz := &server.Connz{Conns: []server.ConnInfo{
+		{Cid: 1, InMsgs: 10, InBytes: 100},
+		{Cid: 2, OutMsgs: 20, OutBytes: 200},
+	}}
+	mark := NewMark(varz, connz, time.Now())
+
+	varz = &server.Varz{InMsgs: 15, OutMsgs: 30, InBytes: 150, OutBytes: 300}
+	connz = &server.Connz{Conns: []server.ConnInfo{
+		{Cid: 2, OutMsgs: 25, OutBytes: 250},
+		{Cid: 3, InMsgs: 5, InBytes: 50, OutMsgs: 5, OutBytes: 50},
+	}}
+	mark.Apply(varz, connz)
+
+	if varz.InMsgs != 5 || varz.OutMsgs != 10 || varz.InBytes != 50 || varz.OutBytes != 100 {
+		t.Fatalf("Wrong server totals since mark. got: %+v", varz)
+	}
+	if c := connz.Conns[0]; c.OutMsgs != 5 || c.OutBytes != 50 {
+		t.Fatalf("Wrong connection totals since mark. got: %+v", c)
+	}
+	if c := connz.Conns[1]; c.InMsgs != 5 || c.OutBytes != 50 {
+		t.Fatalf("Expected totals of new connection to be kept. got: %+v", c)
+	}
+}
+
+func TestSubsChurn(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	now := time.Now()
+	sl := &server.SublistStats{NumInserts: 100, NumRemoves: 50}
+	if churn := engine.subsChurn(sl, now); churn != nil {
+		t.Fatalf("Expected no churn without a previous poll, got: %+v", churn)
+	}
+
+	sl = &server.SublistStats{NumInserts: 120, NumRemoves: 55}
+	churn := engine.subsChurn(sl, now.Add(2*time.Second))
+	if churn == nil || churn.AddedRate != 10 || churn.RemovedRate != 2.5 {
+		t.Fatalf("Wrong churn. expected: +10/s -2.5/s, got: %+v", churn)
+	}
+
+	// Counters going back mean the server restarted
+	sl = &server.SublistStats{NumInserts: 10, NumRemoves: 5}
+	if churn := engine.subsChurn(sl, now.Add(3*time.Second)); churn != nil {
+		t.Fatalf("Expected no churn after a restart, got: %+v", churn)
+	}
+}
+
+func TestGatewayTraffic(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	gatewayz := &Gatewayz{
+		Name: "east",
+		OutboundGateways: map[string]*RemoteGatewayz{
+			"west":  {Connection: &GatewayConnz{OutMsgs: 100, OutBytes: 1000}},
+			"south": {IsConfigured: true},
+		},
+		InboundGateways: map[string][]*RemoteGatewayz{
+			"west": {
+				{Connection: &GatewayConnz{InMsgs: 10, InBytes: 100}},
+				{Connection: &GatewayConnz{InMsgs: 20, InBytes: 200, Pending: 64}},
+			},
+		},
+	}
+	gateways := engine.gatewayTraffic(gatewayz, time.Second)
+	if len(gateways) != 2 || gateways[0].Name != "south" || gateways[1].Name != "west" {
+		t.Fatalf("Expected gateways south and west, got: %+v", gateways)
+	}
+	west := gateways[1]
+	if west.Outbound != 1 || west.Inbound != 2 || west.InMsgs != 30 || west.OutMsgs != 100 || west.Pending != 64 {
+		t.Fatalf("Wrong traffic with west: %+v", west)
+	}
+	if gateways[0].Outbound != 0 {
+		t.Fatalf("Expected gateway without connection to not count, got: %+v", gateways[0])
+	}
+
+	gatewayz.OutboundGateways["west"].Connection.OutMsgs = 300
+	gateways = engine.gatewayTraffic(gatewayz, 2*time.Second)
+	if rate := gateways[1].Rates.OutMsgsRate; rate != 100 {
+		t.Fatalf("Wrong rate to west. expected: 100, got: %v", rate)
+	}
+}
+
+func TestLeafTraffic(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+
+	leafz := &Leafz{Leafs: []*LeafInfo{
+		{Name: "edge-1", IP: "10.0.1.5", Port: 7422, InMsgs: 10, OutMsgs: 100},
+		{Name: "edge-2", IP: "10.0.1.6", Port: 7422, InMsgs: 20},
+	}}
+	leafs := engine.leafTraffic(leafz, time.Second)
+	if len(leafs) != 2 || leafs[0].Name != "edge-1" || leafs[0].Rates.OutMsgsRate != 0 {
+		t.Fatalf("Expected leafnodes without rates on the first poll, got: %+v", leafs)
+	}
+
+	// Leafnodes reconnecting from another port start over
+	leafz = &Leafz{Leafs: []*LeafInfo{
+		{Name: "edge-1", IP: "10.0.1.5", Port: 7422, InMsgs: 10, OutMsgs: 300},
+		{Name: "edge-2", IP: "10.0.1.6", Port: 7423, InMsgs: 5},
+	}}
+	leafs = engine.leafTraffic(leafz, 2*time.Second)
+	if rate := leafs[0].Rates.OutMsgsRate; rate != 100 {
+		t.Fatalf("Wrong rate to edge-1. expected: 100, got: %v", rate)
+	}
+	if rate := leafs[1].Rates.InMsgsRate; rate != 0 {
+		t.Fatalf("Expected no rate from edge-2 after reconnecting, got: %v", rate)
+	}
+
+	// Subscriptions are only polled when listing those of a leafnode
+	if uri := engine.leafzURI(); uri != engine.Uri+"/leafz" {
+		t.Fatalf("Unexpected leafz uri: %s", uri)
+	}
+	engine.LeafDetail = "10.0.1.5:7422"
+	if uri := engine.leafzURI(); uri != engine.Uri+"/leafz?subs=1" {
+		t.Fatalf("Unexpected leafz uri: %s", uri)
+	}
+}
+
+func TestAccountTraffic(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+
+	accstatz := &Accstatz{Accounts: []*AccountStat{
+		{Account: "B", Conns: 2, NumSubs: 5, Sent: DataStats{Msgs: 100}},
+		{Account: "A", Conns: 1, NumSubs: 10, Sent: DataStats{Msgs: 50}},
+	}}
+	accounts := engine.accountTraffic(accstatz, time.Second)
+	if len(accounts) != 2 || accounts[0].Account != "A" || accounts[1].Account != "B" {
+		t.Fatalf("Expected accounts A and B, got: %+v", accounts)
+	}
+
+	accstatz = &Accstatz{Accounts: []*AccountStat{
+		{Account: "B", Conns: 2, NumSubs: 5, Sent: DataStats{Msgs: 300}},
+		{Account: "A", Conns: 1, NumSubs: 10, Sent: DataStats{Msgs: 30}},
+	}}
+	engine.SortOpt = "msgs_to"
+	accounts = engine.accountTraffic(accstatz, 2*time.Second)
+	if accounts[0].Account != "B" || accounts[0].Rates.OutMsgsRate != 100 {
+		t.Fatalf("Wrong rate of B. expected: 100, got: %+v", accounts[0])
+	}
+	if rate := accounts[1].Rates.OutMsgsRate; rate != 0 {
+		t.Fatalf("Expected counters going back to not make the rate negative, got: %v", rate)
+	}
+
+	engine.SortOpt = "subs"
+	accounts = engine.accountTraffic(accstatz, time.Second)
+	if accounts[0].Account != "A" {
+		t.Fatalf("Expected A with more subscriptions first, got: %+v", accounts[0])
+	}
+}
+
+func TestSubTraffic(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	conn := &server.ConnInfo{Cid: 7, Pending: 1000}
+	ext := &ExtConnInfo{SubsDetail: []SubDetails{
+		{Subject: "orders.new", Sid: "1", Msgs: 100},
+		{Subject: "orders.paid", Sid: "2", Msgs: 300},
+	}}
+
+	// Pending bytes are split by all the messages delivered at first
+	subs := engine.subTraffic(conn, ext, time.Second)
+	if len(subs) != 2 || subs[0].Subject != "orders.paid" || subs[0].Pending != 750 || subs[1].Pending != 250 {
+		t.Fatalf("Wrong estimate of pending bytes, got: %+v, %+v", subs[0], subs[1])
+	}
+
+	// Then by the messages delivered since the previous poll
+	ext.SubsDetail[0].Msgs = 500
+	ext.SubsDetail[1].Msgs = 400
+	subs = engine.subTraffic(conn, ext, 2*time.Second)
+	if subs[0].Subject != "orders.new" || subs[0].MsgsRate != 200 || subs[0].Pending != 800 {
+		t.Fatalf("Wrong rate or estimate of orders.new, got: %+v", subs[0])
+	}
+	if subs[1].MsgsRate != 50 || subs[1].Pending != 200 {
+		t.Fatalf("Wrong rate or estimate of orders.paid, got: %+v", subs[1])
+	}
+}
+
+func TestSortConsumers(t *testing.T) {
+	consumers := []*ConsumerInfo{
+		{Name: "a", NumPending: 10, NumAckPending: 3},
+		{Name: "b", NumPending: 50, NumAckPending: 1},
+		{Name: "c", NumPending: 20, NumAckPending: 2},
+	}
+	for _, test := range []struct {
+		opt      server.SortOpt
+		expected string
+	}{
+		{SortByLag, "bca"},
+		{"pending", "acb"},
+		// Consumers have no subscriptions, so are left as they are
+		{"subs", "acb"},
+	} {
+		sortConsumers(test.opt, consumers)
+		var names string
+		for _, consumer := range consumers {
+			names += consumer.Name
+		}
+		if names != test.expected {
+			t.Fatalf("Wrong order sorting by %s. expected: %s, got: %s", test.opt, test.expected, names)
+		}
+	}
+}
+
+func TestSortLeafs(t *testing.T) {
+	leafs := []*LeafInfo{
+		{Name: "a", NumSubs: 1, RTT: "5ms"},
+		{Name: "b", NumSubs: 3},
+		{Name: "c", NumSubs: 2, RTT: "10ms"},
+	}
+	for _, test := range []struct {
+		opt      server.SortOpt
+		expected string
+	}{
+		{"subs", "bca"},
+		{SortByRTT, "cab"},
+		// Leafnodes have no cid, so are left as they are
+		{"cid", "cab"},
+	} {
+		sortLeafs(test.opt, leafs)
+		var names string
+		for _, leaf := range leafs {
+			names += leaf.Name
+		}
+		if names != test.expected {
+			t.Fatalf("Wrong order sorting by %s. expected: %s, got: %s", test.opt, test.expected, names)
+		}
+	}
+}
+
+func TestCountRTTs(t *testing.T) {
+	counts := CountRTTs([]ExtConnInfo{
+		{RTT: "500µs"}, {RTT: "1ms"}, {RTT: "9.9ms"}, {RTT: "50ms"}, {RTT: "100ms"}, {RTT: "2s"}, {},
+	})
+	expected := []int{1, 2, 1, 2}
+	if fmt.Sprint(counts) != fmt.Sprint(expected) {
+		t.Fatalf("Wrong RTT counts. expected: %v, got: %v", expected, counts)
+	}
+}
+
+func TestCountSubjectSubs(t *testing.T) {
+	subjects := CountSubjectSubs([]SubDetails{
+		{Subject: "events.*", Msgs: 1},
+		{Subject: "orders.new", Queue: "workers", Msgs: 10},
+		{Subject: "orders.new", Queue: "workers", Msgs: 20},
+		{Subject: "audit.>", Msgs: 5},
+		{Subject: "orders.new", Msgs: 30},
+	})
+
+	expected := []SubjectSubs{
+		{Subject: "orders.new", Subs: 3, QueueSubs: 2, Msgs: 60},
+		{Subject: "audit.>", Subs: 1, Msgs: 5},
+		{Subject: "events.*", Subs: 1, Msgs: 1},
+	}
+	if len(subjects) != len(expected) {
+		t.Fatalf("Wrong number of subjects. expected: %d, got: %d", len(expected), len(subjects))
+	}
+	for i, s := range expected {
+		if *subjects[i] != s {
+			t.Fatalf("Wrong subject at %d. expected: %+v, got: %+v", i, s, *subjects[i])
+		}
+	}
+}
+
+func TestRateInterval(t *testing.T) {
+	for _, test := range []struct {
+		server, local, expected time.Duration
+	}{
+		// Time of the server is used when consistent with the local one
+		{time.Second, 1100 * time.Millisecond, time.Second},
+		{0, time.Second, time.Second},
+		// Clock of the server jumping back or forward
+		{-time.Hour, time.Second, time.Second},
+		{time.Hour, time.Second, time.Second},
+		{100 * time.Millisecond, time.Second, time.Second},
+	} {
+		if got := RateInterval(test.server, test.local); got != test.expected {
+			t.Fatalf("Wrong interval for server %v and local %v. expected: %v, got: %v",
+				test.server, test.local, test.expected, got)
+		}
+	}
+}
+
+func TestSortOpts(t *testing.T) {
+	for _, opt := range SortOpts {
+		if !IsValidSortOpt(opt) {
+			t.Fatalf("Expected %q to be a valid option to sort by", opt)
+		}
+	}
+	if IsValidSortOpt("bytes") {
+		t.Fatalf("Expected %q to not be a valid option to sort by", "bytes")
+	}
+	if !strings.HasPrefix(SortOptsList(), "cid, subs, ") {
+		t.Fatalf("Wrong list of options to sort by, got: %s", SortOptsList())
+	}
+}
+
+func TestConnzURISortOpts(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+
+	// Options sorted by once polled are not sent to the server
+	for _, opt := range SortOpts {
+		sort := string(opt)
+		if !opt.IsValid() {
+			sort = ""
+		}
+		engine.SortOpt = opt
+		expected := engine.Uri + "/connz?limit=10&sort=" + sort
+		if uri := engine.connzURI(); uri != expected {
+			t.Fatalf("Wrong connz uri sorting by %s. expected: %s, got: %s", opt, expected, uri)
+		}
+	}
+	for _, opt := range []server.SortOpt{SortByRTT, SortByTLS, SortByLag} {
+		engine.SortOpt = opt
+		if uri := engine.connzURI(); strings.Contains(uri, string(opt)) {
+			t.Fatalf("Expected %s to not be requested, got: %s", opt, uri)
+		}
+	}
+}
+
+func TestIdleTime(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		conn     server.ConnInfo
+		idle     time.Duration
+		reported bool
+	}{
+		{server.ConnInfo{Idle: "1h2m"}, time.Hour + 2*time.Minute, true},
+		{server.ConnInfo{LastActivity: now.Add(-10 * time.Minute)}, 10 * time.Minute, true},
+		{server.ConnInfo{}, 0, false},
+	} {
+		idle, ok := IdleTime(&test.conn, now)
+		if idle != test.idle || ok != test.reported {
+			t.Fatalf("Wrong idle time of %+v. expected: %v, got: %v", test.conn, test.idle, idle)
+		}
+	}
+}
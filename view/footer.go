@@ -0,0 +1,42 @@
+package view
+
+import (
+	"strings"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// Footers hinting at the keys used while a prompt or the help are shown.
+const (
+	PromptFooter = "Enter Apply  Backspace Delete"
+	HelpFooter   = "Any key Back"
+	MacroFooter  = "Key Bind  Esc Discard"
+)
+
+// TopFooter returns the footer of the top view, hinting at
+// the keys of the most used commands.
+func TopFooter(keys map[rune]string) string {
+	hints := []struct {
+		action string
+		label  string
+	}{
+		{top.SortAction, "Sort"},
+		{top.LimitAction, "Limit"},
+		{top.SubscriptionsAction, "Subs"},
+		{top.ExportAction, "Export"},
+		{top.HelpAction, "Help"},
+		{top.QuitAction, "Quit"},
+	}
+
+	var footer []string
+	for _, hint := range hints {
+		bound := top.KeysFor(keys, hint.action)
+		if bound == "" {
+			continue
+		}
+		footer = append(footer, bound[:1]+" "+hint.label)
+	}
+	footer = append(footer, "Up/Down Select")
+
+	return strings.Join(footer, "  ")
+}
@@ -0,0 +1,94 @@
+package view
+
+import (
+	"fmt"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// Help returns the text of the help view, listing the
+// commands along with the keys bound to them.
+func Help(keys map[rune]string) string {
+	commands := []struct {
+		action string
+		arg    string
+		desc   string
+	}{
+		{top.SortAction, "<option>", `Set primary sort key to <option>.
+
+                 Option can be one of: {cid|subs|pending|msgs_to|msgs_from|
+                 bytes_to|bytes_from|idle|last|rtt|tls|lag}
+
+                 This can be set in the command line too with -sort flag.`},
+		{top.LimitAction, "<limit>", `Set sample size of connections to request from the server.
+
+                 This can be set in the command line as well via -n flag.
+                 Note that if used in conjunction with sort, the server
+                 would respect both options allowing queries like 'connection
+                 with largest number of subscriptions': -n 1 -sort subs`},
+		{top.SubscriptionsAction, "", `Toggle displaying connection subscriptions, listed
+                 under each connection.`},
+		{top.SublistAction, "", `Toggle displaying sublist statistics from the server.`},
+		{top.AccountsAction, "", `Toggle displaying the number of connections per account,
+                 along with the change since the previous poll.`},
+		{top.RoutesAction, "", `Toggle displaying the subscriptions propagated over each route,
+                 along with the change since the previous poll.`},
+		{top.RTTAction, "", `Toggle displaying a histogram of the round trip times of the
+                 polled connections, for servers reporting them.`},
+		{top.UsersAction, "", `Toggle displaying the user each connection authenticated as,
+                 for servers using user/password or token authentication.`},
+		{top.GroupAction, "", `Toggle grouping the connections by the account and user
+                 they authenticated as, with their summed stats and rates.`},
+		{top.IdleAction, "", `Toggle listing only the connections idle for longer than
+                 -idle_threshold, with the subscriptions and pending they hold.`},
+		{top.RoutezAction, "", `Toggle listing the routes to the other servers of the cluster
+                 instead of the connections, with their pending bytes and totals.`},
+		{top.SubjectsAction, "", `Toggle listing the subjects subscribed to instead of the
+                 connections, with their subscriptions from newer servers.`},
+		{top.GatewayzAction, "", `Toggle listing the remote gateways of the super-cluster instead
+                 of the connections, with the traffic and rates with each.`},
+		{top.LeafzAction, "", `Toggle listing the leafnode connections instead of the client
+                 connections, sorted by the same options when they apply.`},
+		{top.JetStreamAction, "", `Toggle the JetStream overview, listing the accounts using it
+                 instead of the connections with their streams and storage.`},
+		{top.AccountzAction, "", `Toggle listing the accounts of the server instead of the
+                 connections, with the claims and limits of the selected one.`},
+		{top.AlertsAction, "", `Toggle listing the latest alerts fired or resolved during the
+                 session instead of the connections, with their current state.`},
+		{top.AccstatzAction, "", `Toggle listing the accounts with connections instead of the
+                 connections, with their totals and rates, sorted like them.`},
+		{top.ClusterAction, "<name>", `Switch to another one of the clusters defined
+                 in the config file, restarting the measurements.`},
+		{top.RefreshAction, "", `Poll the server right away instead of waiting for the delay.`},
+		{top.ResetAction, "", `Reset the baselines of the rates and the tracked connections,
+                 measuring again from scratch after a known event.`},
+		{top.MarkAction, "", `Toggle displaying the totals relative to the counters at
+                 the time of marking, e.g. to measure the traffic of an operation.`},
+		{top.FlashAction, "", `Toggle highlighting the cells which changed since the previous poll.`},
+		{top.TotalsAction, "", `Toggle a row with the totals of the connections table.`},
+		{top.AgeAction, "", `Toggle coloring the uptime of the connections by their age,
+                 so that fresh reconnects stand out from long-lived ones.`},
+		{top.DNSAction, "", `Toggle activating DNS address lookup for clients.`},
+		{top.ExportAction, "", `Export the current screen to a file in the working
+                 directory, as plain text or html depending on -export.`},
+		{top.MacroAction, "<key>", `Start recording the commands typed, then stop and bind them
+                 to <key>, which replays them. Macros can be set in the config.`},
+		{top.InfoAction, "", `Show the config of the server, like its limits, timeouts
+                 and cluster, leafnode and gateway listeners.`},
+		{top.QuitAction, "", `Quit nats-top.`},
+	}
+
+	text := "\nCommand          Description\n\n"
+	for _, cmd := range commands {
+		bound := top.KeysFor(keys, cmd.action)
+		if bound == "" {
+			continue
+		}
+		text += fmt.Sprintf("%-17s%s\n\n", bound+cmd.arg, cmd.desc)
+	}
+	text += fmt.Sprintf("%-17s%s\n\n", "Up/Down", "Select a connection, which stays selected across polls.")
+	text += fmt.Sprintf("%-17s%s\n\n", "Enter/Esc", "List the subscriptions of the selected connection, the streams\n                 of the selected account in the JetStream view then the consumers\n                 of the selected stream, or the detail of the selected account\n                 in the accounts view, and go back.")
+	text += "Press any key to continue...\n\n"
+
+	return text
+}
@@ -0,0 +1,126 @@
+package view
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// infoSection is a group of settings of the server info page,
+// which is omitted when the server reports none of them.
+type infoSection struct {
+	title string
+	lines []string
+}
+
+// add appends a setting to the section unless its value is empty.
+func (s *infoSection) add(name string, value interface{}) {
+	text := fmt.Sprint(value)
+	if text == "" || text == "0" || text == "0s" {
+		return
+	}
+	s.lines = append(s.lines, fmt.Sprintf("  %-20s%s", name+":", text))
+}
+
+// hostPort returns the address of a listener, or empty if it has no port.
+func hostPort(host string, port int) string {
+	if port == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// ServerInfo returns the page with the config of the server
+// as reported by /varz, grouped by the area of each setting.
+func ServerInfo(stats *top.Stats) string {
+	varz := stats.Varz
+	ext := stats.ExtVarz
+	if ext == nil {
+		ext = &top.ExtVarz{}
+	}
+
+	general := &infoSection{title: "General"}
+	limits := &infoSection{title: "Limits"}
+	timeouts := &infoSection{title: "Timeouts"}
+	security := &infoSection{title: "Security"}
+	monitoring := &infoSection{title: "Monitoring"}
+	cluster := &infoSection{title: "Cluster"}
+	leafnodes := &infoSection{title: "Leafnodes"}
+	gateway := &infoSection{title: "Gateway"}
+
+	if info := varz.Info; info != nil {
+		general.add("Server ID", info.ID)
+		general.add("Listen", hostPort(info.Host, varz.Port))
+		general.add("Version", info.Version)
+		general.add("Go", info.GoVersion)
+		general.add("Client URLs", strings.Join(info.ClientConnectURLs, ", "))
+
+		security.add("Auth Required", info.AuthRequired)
+		security.add("TLS Required", info.TLSRequired)
+		security.add("TLS Verify", info.TLSVerify)
+	}
+	general.add("Name", ext.Name)
+	general.add("Cores", varz.Cores)
+
+	var clusterListen string
+	if opts := varz.Options; opts != nil {
+		limits.add("Max Connections", opts.MaxConn)
+		limits.add("Max Pending", top.Psize(int64(opts.MaxPending)))
+		limits.add("Max Control Line", opts.MaxControlLine)
+
+		timeouts.add("Ping Interval", opts.PingInterval)
+		timeouts.add("Max Pings Out", opts.MaxPingsOut)
+		timeouts.add("Auth Timeout", seconds(opts.AuthTimeout))
+		timeouts.add("TLS Timeout", seconds(opts.TLSTimeout))
+
+		monitoring.add("HTTP", hostPort(opts.HTTPHost, opts.HTTPPort))
+		monitoring.add("HTTPS", hostPort(opts.HTTPHost, opts.HTTPSPort))
+
+		clusterListen = hostPort(opts.ClusterHost, opts.ClusterPort)
+	}
+	limits.add("Max Payload", top.Psize(int64(varz.MaxPayload)))
+	limits.add("Max Subscriptions", ext.MaxSubs)
+	timeouts.add("Write Deadline", ext.WriteDeadline)
+
+	// Newer servers report the cluster config in its own block
+	if c := ext.Cluster; c != nil {
+		cluster.add("Name", c.Name)
+		if c.Port != 0 {
+			clusterListen = hostPort(c.Host, c.Port)
+		}
+	}
+	cluster.add("Listen", clusterListen)
+	if c := ext.Cluster; c != nil {
+		cluster.add("Routes", strings.Join(c.URLs, ", "))
+	}
+	if l := ext.LeafNode; l != nil {
+		leafnodes.add("Listen", hostPort(l.Host, l.Port))
+	}
+	leafnodes.add("Connected", ext.Leafs)
+	if g := ext.Gateway; g != nil {
+		gateway.add("Name", g.Name)
+		gateway.add("Listen", hostPort(g.Host, g.Port))
+		var remotes []string
+		for _, remote := range g.Gateways {
+			remotes = append(remotes, remote.Name)
+		}
+		gateway.add("Remotes", strings.Join(remotes, ", "))
+	}
+
+	text := "\nServer Information\n"
+	for _, section := range []*infoSection{general, limits, timeouts, security, monitoring, cluster, leafnodes, gateway} {
+		if len(section.lines) == 0 {
+			continue
+		}
+		text += "\n" + section.title + ":\n" + strings.Join(section.lines, "\n") + "\n"
+	}
+
+	return text
+}
+
+// seconds returns a duration given in seconds as reported by the server.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,220 @@
+package view
+
+import (
+	"strings"
+
+	ui "gopkg.in/gizak/termui.v1"
+)
+
+// PromptRow is the row of the prompt for options, which is rendered
+// over the blank line that follows the server stats in the top view.
+const PromptRow = 6
+
+// Screen is the backend on which the text of the views is rendered.
+type Screen interface {
+	// SetText sets the text of the current view.
+	SetText(text string)
+
+	// SetTable sets the table shown under the text,
+	// which is hidden when nil.
+	SetTable(table *Table)
+
+	// SetPrompt sets the prompt shown over the current view,
+	// which is hidden when empty.
+	SetPrompt(text string)
+
+	// SetFooter sets the line shown at the bottom of the screen,
+	// which is hidden when empty.
+	SetFooter(text string)
+
+	// Resize adapts the screen to the size of the terminal.
+	Resize(width, height int)
+
+	// Render draws the view and the prompt.
+	Render()
+}
+
+// TermScreen renders the views in the terminal using termui,
+// which has to be initialized before creating it.
+type TermScreen struct {
+	par       *ui.Par
+	table     *TableWidget
+	promptPar *ui.Par
+	footerPar *ui.Par
+	footer    string
+	width     int
+	height    int
+}
+
+// NewTermScreen returns a screen using the whole terminal.
+func NewTermScreen() *TermScreen {
+	par := ui.NewPar("")
+	par.HasBorder = false
+
+	table := NewTableWidget()
+	table.HasBorder = false
+
+	promptPar := ui.NewPar("")
+	promptPar.Y = PromptRow
+	promptPar.Height = 1
+	promptPar.HasBorder = false
+
+	footerPar := ui.NewPar("")
+	footerPar.Height = 1
+	footerPar.HasBorder = false
+	footerPar.TextFgColor = ui.ColorBlack
+	footerPar.TextBgColor = ui.ColorCyan
+
+	s := &TermScreen{par: par, table: table, promptPar: promptPar, footerPar: footerPar}
+	s.Resize(ui.TermWidth(), ui.TermHeight())
+
+	return s
+}
+
+func (s *TermScreen) SetText(text string) {
+	// A trailing newline would be taken as an overflowing line
+	s.par.Text = strings.TrimSuffix(text, "\n")
+	s.layout()
+}
+
+func (s *TermScreen) SetTable(table *Table) {
+	s.table.Table = table
+	s.layout()
+}
+
+// layout places the table right under the text, which takes
+// the whole screen but the footer when there is no table.
+func (s *TermScreen) layout() {
+	height := s.height
+	if s.footer != "" {
+		height--
+	}
+
+	// Padded so that the background covers the whole line
+	s.footerPar.Text = s.footer
+	if n := s.width - len([]rune(s.footer)); n > 0 {
+		s.footerPar.Text += strings.Repeat(" ", n)
+	}
+	s.footerPar.Y = height
+	s.footerPar.Width = s.width
+
+	s.par.Width = s.width
+	s.par.Height = height
+	if s.table.Table != nil {
+		s.par.Height = strings.Count(s.par.Text, "\n") + 1
+	}
+
+	s.table.Y = s.par.Height
+	s.table.Width = s.width
+	s.table.Height = height - s.par.Height
+	if s.table.Height < 0 {
+		s.table.Height = 0
+	}
+	s.promptPar.Width = s.width
+}
+
+func (s *TermScreen) SetPrompt(text string) {
+	s.promptPar.Text = text
+}
+
+func (s *TermScreen) SetFooter(text string) {
+	s.footer = text
+	s.layout()
+}
+
+func (s *TermScreen) Resize(width, height int) {
+	s.width = width
+	s.height = height
+	s.layout()
+}
+
+func (s *TermScreen) Render() {
+	widgets := []ui.Bufferer{s.par}
+	if s.table.Table != nil {
+		widgets = append(widgets, s.table)
+	}
+	if s.promptPar.Text != "" {
+		widgets = append(widgets, s.promptPar)
+	}
+	if s.footer != "" {
+		widgets = append(widgets, s.footerPar)
+	}
+	ui.Render(widgets...)
+}
+
+// TextScreen renders the views as plain text, e.g. for frontends
+// which are not a terminal.
+type TextScreen struct {
+	width  int
+	height int
+	text   string
+	table  *Table
+	prompt string
+	footer string
+	screen string
+}
+
+// NewTextScreen returns a screen of the given size,
+// which is unbounded when the size is zero.
+func NewTextScreen(width, height int) *TextScreen {
+	return &TextScreen{width: width, height: height}
+}
+
+func (s *TextScreen) SetText(text string) {
+	s.text = text
+}
+
+func (s *TextScreen) SetTable(table *Table) {
+	s.table = table
+}
+
+func (s *TextScreen) SetPrompt(text string) {
+	s.prompt = text
+}
+
+func (s *TextScreen) SetFooter(text string) {
+	s.footer = text
+}
+
+func (s *TextScreen) Resize(width, height int) {
+	s.width = width
+	s.height = height
+}
+
+func (s *TextScreen) Render() {
+	text := s.text
+	if s.table != nil {
+		text += s.table.String()
+	}
+
+	lines := strings.Split(text, "\n")
+	if s.prompt != "" {
+		for len(lines) <= PromptRow {
+			lines = append(lines, "")
+		}
+		lines[PromptRow] = s.prompt
+	}
+	height := s.height
+	if s.footer != "" && height > 0 {
+		height--
+	}
+	if height > 0 && len(lines) > height {
+		lines = lines[:height]
+	}
+	if s.footer != "" {
+		lines = append(lines, s.footer)
+	}
+	if s.width > 0 {
+		for i, line := range lines {
+			if r := []rune(line); len(r) > s.width {
+				lines[i] = string(r[:s.width])
+			}
+		}
+	}
+	s.screen = strings.Join(lines, "\n")
+}
+
+// String returns the text last rendered on the screen.
+func (s *TextScreen) String() string {
+	return s.screen
+}
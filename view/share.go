@@ -0,0 +1,106 @@
+package view
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shareWriteTimeout is how long a viewer can take to read a screen
+// before being disconnected.
+const shareWriteTimeout = 5 * time.Second
+
+// clearScreen moves the cursor home and clears the terminal of the viewers.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// Share serves the screens rendered by the UI read-only to the viewers
+// connecting to it, e.g. via telnet, so that others can watch a session.
+type Share struct {
+	mu     sync.Mutex
+	screen string
+	// Each viewer is sent the latest screen by its own goroutine,
+	// so that updating does not wait on any of them.
+	viewers map[net.Conn]chan string
+}
+
+// NewShare returns a share without viewers.
+func NewShare() *Share {
+	return &Share{viewers: make(map[net.Conn]chan string)}
+}
+
+// Serve accepts viewers on the listener, sending them the latest
+// screen right away, until the listener is closed.
+func (s *Share) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		screens := make(chan string, 1)
+		s.mu.Lock()
+		s.viewers[conn] = screens
+		if s.screen != "" {
+			screens <- s.screen
+		}
+		s.mu.Unlock()
+		go s.send(conn, screens)
+
+		// Input of the viewers is discarded, the session being read-only
+		go func() {
+			io.Copy(ioutil.Discard, conn)
+			s.drop(conn)
+		}()
+	}
+}
+
+// Update sends the screen to every viewer, replacing the previous one.
+func (s *Share) Update(screen string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Terminals expect carriage returns along with new lines
+	s.screen = strings.Replace(screen, "\n", "\r\n", -1)
+	for _, screens := range s.viewers {
+		// Viewers behind only need the latest screen, and the slot
+		// is free once emptied since screens are only queued here
+		select {
+		case <-screens:
+		default:
+		}
+		screens <- s.screen
+	}
+}
+
+// Viewers returns the number of viewers connected.
+func (s *Share) Viewers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.viewers)
+}
+
+// send writes the screens queued for a viewer until it is dropped,
+// disconnecting it when failing.
+func (s *Share) send(conn net.Conn, screens chan string) {
+	for screen := range screens {
+		conn.SetWriteDeadline(time.Now().Add(shareWriteTimeout))
+		if _, err := io.WriteString(conn, clearScreen+screen); err != nil {
+			s.drop(conn)
+			return
+		}
+	}
+}
+
+// drop disconnects a viewer.
+func (s *Share) drop(conn net.Conn) {
+	s.mu.Lock()
+	if screens, ok := s.viewers[conn]; ok {
+		delete(s.viewers, conn)
+		close(screens)
+	}
+	s.mu.Unlock()
+	conn.Close()
+}
@@ -0,0 +1,318 @@
+package view
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	ui "gopkg.in/gizak/termui.v1"
+)
+
+// DEFAULT_COLUMN_SEPARATOR is the space between the columns of a table.
+const DEFAULT_COLUMN_SEPARATOR = "  "
+
+// Cell is the value of a column in a row of a table,
+// along with the colors used to display it.
+type Cell struct {
+	Text string
+	Fg   ui.Attribute
+	Bg   ui.Attribute
+}
+
+// steadyColumns change on every poll, so they would always be
+// highlighted when highlighting the cells that changed.
+var steadyColumns = map[string]bool{
+	"UPTIME": true,
+}
+
+// TableRow is a row of a table, identified by the connection it shows.
+type TableRow struct {
+	ID    uint64
+	Cells []Cell
+
+	// Subs is listed under the row when displaying subscriptions.
+	Subs string
+}
+
+// Table is the connections table of the top view.
+type Table struct {
+	Header []string
+	Widths []int
+	Rows   []*TableRow
+
+	// Totals is displayed after the rows when set.
+	Totals *TableRow
+
+	// Selected is the ID of the selected row, or zero when none is.
+	Selected uint64
+}
+
+// NewTable returns a table with the given header, each column
+// being at least as wide as its header or the minimum width.
+func NewTable(header []string, widths []int) *Table {
+	t := &Table{Header: header, Widths: make([]int, len(header))}
+	for i, h := range header {
+		t.Widths[i] = utf8.RuneCountInString(h)
+		if i < len(widths) && widths[i] > t.Widths[i] {
+			t.Widths[i] = widths[i]
+		}
+	}
+	return t
+}
+
+// AddRow appends a row to the table, widening the columns
+// which are not wide enough for its cells.
+func (t *Table) AddRow(id uint64, cells ...Cell) *TableRow {
+	t.widen(cells)
+	row := &TableRow{ID: id, Cells: cells}
+	t.Rows = append(t.Rows, row)
+	return row
+}
+
+// SetTotals sets the row summing the columns of the table,
+// widening the columns like AddRow.
+func (t *Table) SetTotals(cells ...Cell) *TableRow {
+	t.widen(cells)
+	t.Totals = &TableRow{Cells: cells}
+	return t.Totals
+}
+
+// widen widens the columns which are not wide enough for the cells.
+func (t *Table) widen(cells []Cell) {
+	for i, cell := range cells {
+		if w := utf8.RuneCountInString(cell.Text); i < len(t.Widths) && w > t.Widths[i] {
+			t.Widths[i] = w
+		}
+	}
+}
+
+// SelectedIndex returns the index of the selected row, or -1.
+func (t *Table) SelectedIndex() int {
+	if t.Selected == 0 {
+		return -1
+	}
+	for i, row := range t.Rows {
+		if row.ID == t.Selected {
+			return i
+		}
+	}
+	return -1
+}
+
+// Move selects the row which is n rows away from the selected one,
+// or the first row when none is selected.
+func (t *Table) Move(n int) {
+	if len(t.Rows) == 0 {
+		return
+	}
+	i := t.SelectedIndex()
+	if i < 0 {
+		i = 0
+	} else {
+		i += n
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(t.Rows) {
+		i = len(t.Rows) - 1
+	}
+	t.Selected = t.Rows[i].ID
+}
+
+// HighlightChanges sets the background of the cells which changed since
+// the previous table, comparing the rows showing the same connection.
+func (t *Table) HighlightChanges(prev *Table, bg ui.Attribute) {
+	if prev == nil || len(prev.Header) != len(t.Header) {
+		return
+	}
+	for i, h := range t.Header {
+		if prev.Header[i] != h {
+			return
+		}
+	}
+
+	prevRows := make(map[uint64]*TableRow)
+	for _, row := range prev.Rows {
+		prevRows[row.ID] = row
+	}
+	for _, row := range t.Rows {
+		prevRow, ok := prevRows[row.ID]
+		if !ok {
+			continue
+		}
+		for i := range row.Cells {
+			if i >= len(prevRow.Cells) || steadyColumns[t.Header[i]] {
+				continue
+			}
+			if row.Cells[i].Text != prevRow.Cells[i].Text {
+				row.Cells[i].Bg = bg
+			}
+		}
+	}
+}
+
+// line returns the cells of a row padded to the width of the columns.
+func (t *Table) line(cells []string) string {
+	line := DEFAULT_PADDING
+	for i, cell := range cells {
+		if i > 0 {
+			line += DEFAULT_COLUMN_SEPARATOR
+		}
+		line += cell
+		if i < len(t.Widths) {
+			line += strings.Repeat(" ", t.Widths[i]-utf8.RuneCountInString(StripColors(cell)))
+		}
+	}
+	return line
+}
+
+// String returns the table as plain text.
+func (t *Table) String() string {
+	return t.text(false)
+}
+
+// Markup returns the table as text with the colors of its cells in
+// the markup of the UI, like the text of the views.
+func (t *Table) Markup() string {
+	return t.text(true)
+}
+
+func (t *Table) text(colored bool) string {
+	text := t.line(t.Header) + "\n"
+	rows := t.Rows
+	if t.Totals != nil {
+		rows = append(rows[:len(rows):len(rows)], t.Totals)
+	}
+	for _, row := range rows {
+		cells := make([]string, len(row.Cells))
+		for i, cell := range row.Cells {
+			cells[i] = cell.Text
+			if name := colorName(cell.Fg); colored && name != "" && cell.Text != "" {
+				cells[i] = fmt.Sprintf("[%s](fg-%s)", cell.Text, name)
+			}
+		}
+		text += t.line(cells) + "\n"
+		text += row.Subs
+	}
+	return text
+}
+
+// TableWidget renders a table in the terminal with its header fixed
+// at the top, scrolling the rows to keep the selected one visible.
+type TableWidget struct {
+	ui.Block
+	Table *Table
+
+	// first row being displayed
+	offset int
+}
+
+// NewTableWidget returns a widget for rendering tables.
+func NewTableWidget() *TableWidget {
+	return &TableWidget{Block: *ui.NewBlock()}
+}
+
+// Buffer implements the termui Bufferer interface.
+func (w *TableWidget) Buffer() []ui.Point {
+	ps := w.Block.Buffer()
+	if w.Table == nil {
+		return ps
+	}
+	x, y, width, height := w.InnerBounds()
+
+	// Lines taken by each row, including its subscriptions
+	rowHeight := func(row *TableRow) int {
+		return 1 + strings.Count(row.Subs, "\n")
+	}
+
+	// Totals stay at the bottom while the rows scroll
+	if w.Table.Totals != nil {
+		height -= rowHeight(w.Table.Totals)
+	}
+
+	// Scroll to the selected row, otherwise keep the offset
+	// unless the rows shrank since the previous render.
+	if selected := w.Table.SelectedIndex(); selected >= 0 {
+		if selected < w.offset {
+			w.offset = selected
+		}
+		for {
+			h := 0
+			for _, row := range w.Table.Rows[w.offset : selected+1] {
+				h += rowHeight(row)
+			}
+			if h < height || w.offset == selected {
+				break
+			}
+			w.offset++
+		}
+	}
+	if w.offset >= len(w.Table.Rows) {
+		w.offset = 0
+	}
+
+	ps = append(ps, w.text(w.Table.line(w.Table.Header), x, y, width, ui.ColorDefault|ui.AttrBold, ui.ColorDefault)...)
+	line := 1
+	for _, row := range w.Table.Rows[w.offset:] {
+		if line >= height {
+			break
+		}
+
+		bg := ui.ColorDefault
+		if row.ID == w.Table.Selected {
+			bg = ui.ColorBlue
+			ps = append(ps, w.text(strings.Repeat(" ", width), x, y+line, width, ui.ColorDefault, bg)...)
+		}
+
+		col := x + len(DEFAULT_PADDING)
+		for i, cell := range row.Cells {
+			if i >= len(w.Table.Widths) {
+				break
+			}
+			cellBg := bg
+			if cell.Bg != ui.ColorDefault && row.ID != w.Table.Selected {
+				cellBg = cell.Bg
+			}
+			ps = append(ps, w.text(cell.Text, col, y+line, width-(col-x), cell.Fg, cellBg)...)
+			col += w.Table.Widths[i] + len(DEFAULT_COLUMN_SEPARATOR)
+		}
+		line++
+
+		for _, sub := range strings.Split(strings.TrimSuffix(row.Subs, "\n"), "\n") {
+			if sub == "" || line >= height {
+				continue
+			}
+			ps = append(ps, w.text(sub, x, y+line, width, ui.ColorDefault, ui.ColorDefault)...)
+			line++
+		}
+	}
+
+	if totals := w.Table.Totals; totals != nil && height > 0 {
+		cells := make([]string, len(totals.Cells))
+		for i, cell := range totals.Cells {
+			cells[i] = cell.Text
+		}
+		ps = append(ps, w.text(w.Table.line(cells), x, y+height, width, ui.ColorDefault|ui.AttrBold, ui.ColorDefault)...)
+		for i, sub := range strings.Split(strings.TrimSuffix(totals.Subs, "\n"), "\n") {
+			if sub != "" {
+				ps = append(ps, w.text(sub, x, y+height+1+i, width, ui.ColorDefault, ui.ColorDefault)...)
+			}
+		}
+	}
+
+	return ps
+}
+
+// text returns the points for a text starting at a position,
+// which is cropped to the given width.
+func (w *TableWidget) text(s string, x, y, width int, fg, bg ui.Attribute) []ui.Point {
+	var ps []ui.Point
+	for i, r := range []rune(s) {
+		if i >= width {
+			break
+		}
+		ps = append(ps, ui.Point{Ch: r, X: x + i, Y: y, Fg: fg, Bg: bg})
+	}
+	return ps
+}
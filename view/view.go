@@ -0,0 +1,1431 @@
+// Copyright (c) 2015 NATS Messaging System
+
+// Package view renders the stats polled by the engine as the text
+// of the views, independently of the terminal on which they are shown.
+package view
+
+import (
+	"fmt"
+	"html"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+	ui "gopkg.in/gizak/termui.v1"
+)
+
+const (
+	DEFAULT_PADDING = "  "
+
+	DEFAULT_HOST_PADDING_SIZE = 15
+
+	DEFAULT_MAX_SUBS_DISPLAYED = 10
+
+	DEFAULT_GAUGE_WIDTH = 30
+)
+
+// colors maps the colors of the rules from the config to termui,
+// which uses the default color for the empty one.
+var colors = map[string]ui.Attribute{
+	"black":   ui.ColorBlack,
+	"red":     ui.ColorRed,
+	"green":   ui.ColorGreen,
+	"yellow":  ui.ColorYellow,
+	"blue":    ui.ColorBlue,
+	"magenta": ui.ColorMagenta,
+	"cyan":    ui.ColorCyan,
+	"white":   ui.ColorWhite,
+}
+
+// View generates the text of the views from the stats
+// polled by the engine.
+type View struct {
+	Engine *top.Engine
+
+	// LookupDNS enables looking up the hosts of the clients.
+	LookupDNS bool
+
+	// Subject filters the subscriptions listed under each connection.
+	Subject string
+
+	// Columns replace the default columns of the connections table.
+	Columns []top.Column
+
+	// Totals adds a row summing the connections table.
+	Totals bool
+
+	// AgeColors colors the uptime of the connections by their age.
+	AgeColors bool
+
+	// Colors highlights parts of the text, like the health of the
+	// server, using the markup of the terminal UI.
+	Colors bool
+
+	// ViewOptions are those displayed, which the UI changes
+	// along with those of the engine.
+	top.ViewOptions
+
+	// JetStreamAccount lists the streams of the account
+	// in the JetStream view instead of the accounts.
+	JetStreamAccount string
+
+	// JetStreamStream lists the consumers of the stream
+	// of JetStreamAccount instead of its streams.
+	JetStreamStream string
+
+	// cache for reducing DNS lookups in case enabled
+	resolvedHosts map[string]string
+}
+
+// NewView returns a view of the stats polled by the engine.
+func NewView(engine *top.Engine) *View {
+	return &View{
+		Engine:        engine,
+		ViewOptions:   engine.ViewOptions,
+		resolvedHosts: make(map[string]string),
+	}
+}
+
+// Text takes the latest Stats and returns the formatted
+// server stats which are rendered above the connections table.
+func (v *View) Text(stats *top.Stats) string {
+
+	// Snapshot current stats
+	cpu := stats.Varz.CPU
+	memVal := stats.Varz.Mem
+	uptime := stats.Varz.Uptime
+	numConns := stats.Connz.NumConns
+	inMsgsVal := stats.Varz.InMsgs
+	outMsgsVal := stats.Varz.OutMsgs
+	inBytesVal := stats.Varz.InBytes
+	outBytesVal := stats.Varz.OutBytes
+	slowConsumers := stats.Varz.SlowConsumers
+
+	var serverVersion string
+	if stats.Varz.Info != nil {
+		serverVersion = stats.Varz.Info.Version
+	}
+
+	// Server wide totals would include other accounts and users
+	if stats.Options.Scoped {
+		inMsgsVal, outMsgsVal, inBytesVal, outBytesVal = top.ConnzTotals(stats.Connz)
+	}
+
+	mem := top.Psize(memVal)
+	inMsgs := top.Psize(inMsgsVal)
+	outMsgs := top.Psize(outMsgsVal)
+	inBytes := top.Psize(inBytesVal)
+	outBytes := top.Psize(outBytesVal)
+	inMsgsRate := stats.Rates.InMsgsRate
+	outMsgsRate := stats.Rates.OutMsgsRate
+	inBytesRate := top.Psize(int64(stats.Rates.InBytesRate))
+	outBytesRate := top.Psize(int64(stats.Rates.OutBytesRate))
+
+	// Break down slow consumers by connection kind when reported
+	var slowConsumersKinds string
+	if stats.ExtVarz != nil && stats.ExtVarz.SlowConsumersStats != nil {
+		sc := stats.ExtVarz.SlowConsumersStats
+		slowConsumersKinds = fmt.Sprintf(" (clients: %d, routes: %d, gateways: %d, leafs: %d)",
+			sc.Clients, sc.Routes, sc.Gateways, sc.Leafs)
+	}
+
+	// Leafnodes and gateways are only reported by newer servers
+	var leafs, gateways int
+	if stats.ExtVarz != nil {
+		leafs = stats.ExtVarz.Leafs
+		if stats.ExtVarz.Gateway != nil {
+			gateways = len(stats.ExtVarz.Gateway.Gateways)
+		}
+	}
+
+	// Name of the cluster being monitored when set in the config
+	var cluster string
+	if stats.Options.Cluster != "" {
+		cluster = fmt.Sprintf(" (cluster: %s)", stats.Options.Cluster)
+	}
+
+	// Health of the server when reported, which is green when ok,
+	// yellow when it could not be polled and red otherwise.
+	var health string
+	if h := stats.Healthz; h != nil {
+		color := "red"
+		switch h.Status {
+		case "ok":
+			color = "green"
+		case top.HealthUnknown:
+			color = "yellow"
+		}
+		health = " Health: " + v.colored(h.Status, color)
+		if h.Error != "" {
+			health += fmt.Sprintf(" (%s)", h.Error)
+		}
+	}
+
+	// Notes on the switch to another server after polling failed,
+	// and on the totals being relative to a mark.
+	var notes string
+	if stats.Failover != "" {
+		notes = fmt.Sprintf(" (%s)", stats.Failover)
+	}
+
+	if stats.Mark != nil {
+		notes += fmt.Sprintf(" (totals since mark at %s)", stats.Mark.Time.Format("15:04:05"))
+	}
+
+	info := "NATS server version %s (uptime: %s)%s%s%s %s"
+	info += "\nServer:\n  Load: CPU:  %.1f%%  Memory: %s  Slow Consumers: %d%s\n"
+	info += "  In:   Msgs: %s  Bytes: %s  Msgs/Sec: %.1f  Bytes/Sec: %s\n"
+	info += "  Out:  Msgs: %s  Bytes: %s  Msgs/Sec: %.1f  Bytes/Sec: %s\n"
+	info += "  Subs: %d  Routes: %d  Remotes: %d  Leafnodes: %d  Gateways: %d"
+
+	text := fmt.Sprintf(info, serverVersion, uptime, health, cluster, notes, stats.Error,
+		cpu, mem, slowConsumers, slowConsumersKinds,
+		inMsgs, inBytes, inMsgsRate, inBytesRate,
+		outMsgs, outBytes, outMsgsRate, outBytesRate,
+		stats.Varz.Subscriptions, stats.Varz.Routes, stats.Varz.Remotes, leafs, gateways)
+
+	if (v.DisplaySublist || v.ListSubjects) && stats.Subsz != nil && stats.Subsz.SublistStats != nil {
+		sl := stats.Subsz.SublistStats
+		text += fmt.Sprintf("\n\nSublist: Subs: %d  Cache: %d  Hit Rate: %.1f%%  Fanout: max %d avg %.1f",
+			sl.NumSubs, sl.NumCache, sl.CacheHitRate*100, sl.MaxFanout, sl.AvgFanout)
+		text += fmt.Sprintf("  Inserts: %s  Removes: %s  Matches: %s",
+			top.Psize(int64(sl.NumInserts)), top.Psize(int64(sl.NumRemoves)), top.Psize(int64(sl.NumMatches)))
+		if churn := stats.SubsChurn; churn != nil {
+			text += fmt.Sprintf("  Churn: +%.1f/s -%.1f/s", churn.AddedRate, churn.RemovedRate)
+		}
+	}
+	if jsz := stats.Jsz; jsz != nil && !jsz.Disabled && jsz.Config != nil {
+		text += fmt.Sprintf("\n\nJetStream: Memory: %s / %s  Storage: %s / %s",
+			top.Psize(int64(jsz.Memory)), top.Psize(jsz.Config.MaxMemory),
+			top.Psize(int64(jsz.Store)), top.Psize(jsz.Config.MaxStore))
+		text += "\n  Memory:  " + gauge(jsz.Memory, jsz.ReservedMemory, jsz.Config.MaxMemory)
+		text += "\n  Storage: " + gauge(jsz.Store, jsz.ReservedStore, jsz.Config.MaxStore)
+		if v.ListJetStream {
+			var errPct float64
+			if jsz.API.Total > 0 {
+				errPct = float64(jsz.API.Errors) / float64(jsz.API.Total) * 100
+			}
+			text += fmt.Sprintf("\n  Streams: %d  Consumers: %d  Messages: %s  Bytes: %s  API Requests: %s  Errors: %s (%.1f%%)",
+				jsz.Streams, jsz.Consumers, top.Psize(int64(jsz.Messages)), top.Psize(int64(jsz.Bytes)),
+				top.Psize(int64(jsz.API.Total)), top.Psize(int64(jsz.API.Errors)), errPct)
+		}
+	} else if v.ListJetStream {
+		text += "\n\nJetStream: not enabled on this server"
+	}
+
+	if v.DisplayAccounts && len(stats.AccountConns) > 0 {
+		text += "\n\nAccounts:"
+		for _, acc := range stats.AccountConns {
+			name := acc.Account
+			if name == "" {
+				name = "-"
+			}
+			text += fmt.Sprintf("\n  %-20s  Conns: %-6d (%+d)", name, acc.Conns, acc.Delta)
+		}
+	}
+
+	if v.DisplayRoutes && len(stats.RouteSubs) > 0 {
+		text += "\n\nRoutes:"
+		for _, route := range stats.RouteSubs {
+			text += fmt.Sprintf("\n  %-6d %-21s  Subs: %-8d (%+d)", route.Rid, route.Remote, route.Subs, route.Delta)
+		}
+	}
+
+	if v.DisplayRTT && stats.ExtConnz != nil {
+		text += "\n\n" + rttHistogram(top.CountRTTs(stats.ExtConnz.Conns))
+	}
+
+	if len(stats.Alerts) > 0 {
+		text += "\n\nAlerts:"
+		for _, alert := range stats.Alerts {
+			text += fmt.Sprintf("\n  [%s] %s", alert.Since.Format("15:04:05"), alert.Message)
+		}
+	}
+
+	text += "\n\n"
+	if stats.Options.Account != "" {
+		text += fmt.Sprintf("Account: %s  ", stats.Options.Account)
+	}
+	if stats.Options.User != "" {
+		text += fmt.Sprintf("User: %s  ", stats.Options.User)
+	}
+	if len(v.Engine.CIDRs) > 0 {
+		var cidrs []string
+		for _, ipNet := range v.Engine.CIDRs {
+			cidrs = append(cidrs, ipNet.String())
+		}
+		text += fmt.Sprintf("CIDR: %s  ", strings.Join(cidrs, ","))
+	}
+	if v.IdleOnly {
+		text += idleSummary(stats.Connz, v.Engine.IdleThreshold)
+	}
+	text += fmt.Sprintf("Connections Polled: %d", numConns)
+	if v.GroupByUser {
+		text += fmt.Sprintf("  Users: %d", len(stats.UserConns))
+	}
+	if v.ListRoutes && stats.Routez != nil {
+		text += fmt.Sprintf("  Routes: %d", len(stats.Routez.Routes))
+	} else if v.ListSubjects && stats.ExtSubsz != nil {
+		text += fmt.Sprintf("  Subjects: %d", len(stats.SubjectSubs))
+		if listed := len(stats.ExtSubsz.Subs); listed < stats.ExtSubsz.Total {
+			text += fmt.Sprintf(" (%d of %d subscriptions listed)", listed, stats.ExtSubsz.Total)
+		} else if listed == 0 && stats.Varz.Subscriptions > 0 {
+			text += " (subscriptions not listed by this server)"
+		}
+	} else if v.ListGateways {
+		if stats.Gatewayz != nil {
+			text += fmt.Sprintf("  Gateways: %d", len(stats.Gateways))
+		} else {
+			text += "  Gateways: not reported by this server"
+		}
+	} else if v.ListJetStream && stats.Jsz != nil {
+		if stream := v.jetstreamStream(stats); stream != nil {
+			text += fmt.Sprintf("  Consumers of %s: %d", stream.Name, len(stream.Consumers))
+		} else if acc := v.jetstreamAccount(stats); acc != nil && v.JetStreamStream == "" {
+			text += fmt.Sprintf("  Streams of %s: %d", acc.Name, len(acc.Streams))
+		} else {
+			text += fmt.Sprintf("  JetStream Accounts: %d", len(stats.Jsz.AccountDetails))
+		}
+	} else if v.ListLeafs && v.LeafDetail != "" {
+		text += leafDetailText(v.LeafDetail, stats)
+	} else if v.ListLeafs {
+		if stats.Leafz != nil {
+			text += fmt.Sprintf("  Leafnodes: %d", len(stats.Leafz.Leafs))
+		} else {
+			text += "  Leafnodes: not reported by this server"
+		}
+	} else if v.ListAccountStats {
+		if stats.Accstatz != nil {
+			text += fmt.Sprintf("  Accounts: %d", len(stats.AccountTraffic))
+		} else {
+			text += "  Accounts: not reported by this server"
+		}
+	} else if v.ListAlerts && v.Engine.Session != nil {
+		text += fmt.Sprintf("  Alert Events: %d", len(v.Engine.Session.AlertHistory()))
+	} else if v.ListAccounts {
+		if acc := v.accountDetail(stats); acc != nil {
+			text += fmt.Sprintf("  Account: %s", acc.Name)
+		} else if stats.Accountz != nil && v.AccountDetail == "" {
+			text += fmt.Sprintf("  Accounts: %d", len(stats.Accountz.Accounts))
+		} else if stats.Accountz == nil {
+			text += "  Accounts: not reported by this server"
+		}
+	} else if v.ConnDetail != 0 {
+		text += connDetailText(v.ConnDetail, stats)
+	}
+	text += "\n"
+	return text
+}
+
+// colored returns the text in the color when colors are enabled.
+func (v *View) colored(text, color string) string {
+	if !v.Colors {
+		return text
+	}
+	return fmt.Sprintf("[%s](fg-%s)", text, color)
+}
+
+// colorName returns the name of a color in the markup of the UI,
+// or an empty string for the default one.
+func colorName(color ui.Attribute) string {
+	for name, c := range colors {
+		if c == color {
+			return name
+		}
+	}
+	return ""
+}
+
+// colorMarkup matches the text colored using the markup of the UI.
+var colorMarkup = regexp.MustCompile(`\[([^\]]*)\]\(fg-([a-z]+)\)`)
+
+// StripColors removes the colors from the text of a view.
+func StripColors(text string) string {
+	return colorMarkup.ReplaceAllString(text, "$1")
+}
+
+// ansiColors are the codes of the colors in terminals.
+var ansiColors = map[string]int{
+	"black":   30,
+	"red":     31,
+	"green":   32,
+	"yellow":  33,
+	"blue":    34,
+	"magenta": 35,
+	"cyan":    36,
+	"white":   37,
+}
+
+// ANSI renders the colors of the text of a view as the escape codes
+// of terminals, e.g. for viewers of a shared session.
+func ANSI(text string) string {
+	return renderColors(text, func(s string) string { return s }, func(s, color string) string {
+		code, ok := ansiColors[color]
+		if !ok {
+			return s
+		}
+		return fmt.Sprintf("\x1b[%dm%s\x1b[0m", code, s)
+	})
+}
+
+// HTML escapes the text of a view, rendering its colors as spans.
+func HTML(text string) string {
+	return renderColors(text, html.EscapeString, func(s, color string) string {
+		return fmt.Sprintf("<span style=\"color: %s\">%s</span>", color, html.EscapeString(s))
+	})
+}
+
+// renderColors renders the colored parts of the text of a view,
+// converting the rest via plain.
+func renderColors(text string, plain func(string) string, colored func(s, color string) string) string {
+	var rendered string
+	last := 0
+	for _, m := range colorMarkup.FindAllStringSubmatchIndex(text, -1) {
+		rendered += plain(text[last:m[0]])
+		rendered += colored(text[m[2]:m[3]], text[m[4]:m[5]])
+		last = m[1]
+	}
+	return rendered + plain(text[last:])
+}
+
+// Paragraph takes the latest Stats and returns
+// a formatted paragraph ready to be rendered.
+func (v *View) Paragraph(stats *top.Stats) string {
+	return v.Text(stats) + v.Table(stats).String()
+}
+
+// Table returns the table of the polled connections,
+// using the columns from the config when defined.
+func (v *View) Table(stats *top.Stats) *Table {
+	if v.ListRoutes {
+		return v.routesTable(stats)
+	}
+	if v.ListSubjects {
+		return v.subjectsTable(stats)
+	}
+	if v.ListGateways {
+		return v.gatewaysTable(stats)
+	}
+	if v.ListLeafs && v.LeafDetail != "" {
+		return v.leafSubsTable(stats)
+	}
+	if v.ListLeafs {
+		return v.leafsTable(stats)
+	}
+	if v.ListAccountStats {
+		return v.accountStatsTable(stats)
+	}
+	if v.ListAlerts {
+		return v.alertsTable(stats)
+	}
+	if v.ListAccounts && v.AccountDetail != "" {
+		return v.accountTable(stats)
+	}
+	if v.ListAccounts {
+		return v.accountsTable(stats)
+	}
+	if v.ListJetStream && v.JetStreamStream != "" {
+		return v.consumersTable(stats)
+	}
+	if v.ListJetStream && v.JetStreamAccount != "" {
+		return v.streamsTable(stats)
+	}
+	if v.ListJetStream {
+		return v.jetstreamTable(stats)
+	}
+	if v.ConnDetail != 0 {
+		return v.connSubsTable(stats)
+	}
+	if v.GroupByUser {
+		return v.usersTable(stats)
+	}
+	if len(v.Columns) > 0 {
+		return v.columnsTable(stats)
+	}
+
+	// Disable name unless we have seen one using it
+	withName := false
+	for _, conn := range stats.Connz.Conns {
+		if conn.Name != "" {
+			withName = true
+		}
+	}
+
+	// RTT and kind are only reported by newer servers
+	withRTT, withKind := false, false
+	if stats.ExtConnz != nil {
+		for _, conn := range stats.ExtConnz.Conns {
+			if conn.RTT != "" {
+				withRTT = true
+			}
+			if conn.Kind != "" || conn.Type != "" {
+				withKind = true
+			}
+		}
+	}
+
+	header := []string{"HOST", "CID"}
+	widths := []int{DEFAULT_HOST_PADDING_SIZE, 6}
+	if withKind {
+		header = append(header, "TYPE")
+		widths = append(widths, 6)
+	}
+	if withName {
+		header = append(header, "NAME")
+		widths = append(widths, 0)
+	}
+	if v.DisplayUsers {
+		header = append(header, "USER")
+		widths = append(widths, 0)
+	}
+	header = append(header, "TLS", "SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM", "LANG", "VERSION", "UPTIME")
+	widths = append(widths, 5, 6, 10, 10, 10, 10, 10, 7, 7, 7)
+	if withRTT {
+		header = append(header, "RTT")
+		widths = append(widths, 10)
+	}
+	header = append(header, "IDLE")
+	widths = append(widths, 0)
+
+	// Idle time is measured against the clock of the server
+	now := stats.Varz.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	table := NewTable(header, widths)
+	for i, conn := range stats.Connz.Conns {
+		var ext *top.ExtConnInfo
+		if stats.ExtConnz != nil && i < len(stats.ExtConnz.Conns) {
+			ext = &stats.ExtConnz.Conns[i]
+		}
+
+		cells := []Cell{{Text: v.hostname(conn.IP, conn.Port)}, {Text: fmt.Sprintf("%d", conn.Cid)}}
+		if withKind {
+			var kind string
+			if ext != nil {
+				kind = top.ConnKind(ext)
+			}
+			cells = append(cells, Cell{Text: kind})
+		}
+
+		// Name not included unless present
+		if withName {
+			cells = append(cells, Cell{Text: conn.Name})
+		}
+		if v.DisplayUsers {
+			cells = append(cells, Cell{Text: conn.AuthorizedUser})
+		}
+
+		// Plain connections stand out from the TLS ones
+		tls := Cell{Text: conn.TLSVersion}
+		if tls.Text == "" {
+			tls = Cell{Text: "plain", Fg: ui.ColorRed}
+		}
+
+		cells = append(cells,
+			tls,
+			Cell{Text: fmt.Sprintf("%d", conn.NumSubs)},
+			Cell{Text: top.Psize(int64(conn.Pending))},
+			Cell{Text: top.Psize(conn.OutMsgs)},
+			Cell{Text: top.Psize(conn.InMsgs)},
+			Cell{Text: top.Psize(conn.OutBytes)},
+			Cell{Text: top.Psize(conn.InBytes)},
+			Cell{Text: conn.Lang},
+			Cell{Text: conn.Version},
+			v.uptimeCell(conn.Uptime),
+		)
+		if withRTT {
+			var rtt string
+			if ext != nil {
+				rtt = ext.RTT
+			}
+			cells = append(cells, Cell{Text: rtt})
+		}
+		idle := top.FormatValue(conn.Idle, top.DurationFormat)
+		if idle == "" && !conn.LastActivity.IsZero() {
+			idle = top.HumanDuration(now.Sub(conn.LastActivity))
+		}
+		cells = append(cells, Cell{Text: idle})
+
+		row := table.AddRow(conn.Cid, cells...)
+		row.Subs = v.subsLine(conn.Subs)
+	}
+
+	if v.Totals {
+		v.setTotals(table, stats.Connz)
+	}
+
+	return table
+}
+
+// idleSummary returns the number of connections idle over the threshold
+// along with the subscriptions and pending bytes they hold, which are
+// the resources kept by zombie clients.
+func idleSummary(connz *gnatsd.Connz, threshold time.Duration) string {
+	var subs uint32
+	var pending int
+	for _, conn := range connz.Conns {
+		subs += conn.NumSubs
+		pending += conn.Pending
+	}
+	return fmt.Sprintf("Idle over %s: %d (subs: %d, pending: %s)  ",
+		top.HumanDuration(threshold), len(connz.Conns), subs, top.Psize(int64(pending)))
+}
+
+// uptimeCell returns the uptime of a connection, colored by its age
+// when enabled so that fresh reconnects stand out from long-lived
+// connections: red when just connected, then yellow for minutes,
+// green for hours and cyan for days.
+func (v *View) uptimeCell(uptime string) Cell {
+	cell := Cell{Text: top.FormatValue(uptime, top.DurationFormat)}
+	if !v.AgeColors {
+		return cell
+	}
+
+	d, err := top.ParseUptime(uptime)
+	switch {
+	case err != nil:
+	case d < time.Minute:
+		cell.Fg = ui.ColorRed
+	case d < time.Hour:
+		cell.Fg = ui.ColorYellow
+	case d < 24*time.Hour:
+		cell.Fg = ui.ColorGreen
+	default:
+		cell.Fg = ui.ColorCyan
+	}
+	return cell
+}
+
+// setTotals sets the row summing the subscriptions, pending bytes,
+// msgs and bytes of the connections in the table, noting those
+// which are not displayed due to the limit or the filters.
+func (v *View) setTotals(table *Table, connz *gnatsd.Connz) {
+	var subs uint32
+	var pending int
+	for _, conn := range connz.Conns {
+		subs += conn.NumSubs
+		pending += conn.Pending
+	}
+	inMsgs, outMsgs, inBytes, outBytes := top.ConnzTotals(connz)
+
+	cells := make([]Cell, len(table.Header))
+	cells[0] = Cell{Text: "TOTAL"}
+	for i, h := range table.Header {
+		if h != "SUBS" {
+			continue
+		}
+		cells[i] = Cell{Text: fmt.Sprintf("%d", subs)}
+		cells[i+1] = Cell{Text: top.Psize(int64(pending))}
+		cells[i+2] = Cell{Text: top.Psize(outMsgs)}
+		cells[i+3] = Cell{Text: top.Psize(inMsgs)}
+		cells[i+4] = Cell{Text: top.Psize(outBytes)}
+		cells[i+5] = Cell{Text: top.Psize(inBytes)}
+	}
+
+	row := table.SetTotals(cells...)
+	if hidden := connz.Total - connz.NumConns; hidden > 0 {
+		row.Subs = fmt.Sprintf("%s%d more connections are not displayed due to the limit or filters\n", DEFAULT_PADDING, hidden)
+	}
+}
+
+// columnsTable returns the connections table using the
+// columns defined in the config.
+func (v *View) columnsTable(stats *top.Stats) *Table {
+	var header []string
+	var widths []int
+	for _, col := range v.Columns {
+		header = append(header, col.Header)
+		widths = append(widths, col.Width)
+	}
+
+	table := NewTable(header, widths)
+	for i, conn := range stats.Connz.Conns {
+		var ext *top.ExtConnInfo
+		if stats.ExtConnz != nil && i < len(stats.ExtConnz.Conns) {
+			ext = &stats.ExtConnz.Conns[i]
+		}
+
+		var cells []Cell
+		for _, col := range v.Columns {
+			var cell Cell
+			if col.Field == top.HostField {
+				cell.Text = v.hostname(conn.IP, conn.Port)
+			} else if val, ok := top.ConnField(&conn, ext, col.Field); ok {
+				cell.Text = top.FormatValue(val, col.Format)
+				cell.Fg = colors[col.Color(val)]
+			}
+			cells = append(cells, cell)
+		}
+
+		row := table.AddRow(conn.Cid, cells...)
+		row.Subs = v.subsLine(conn.Subs)
+	}
+
+	return table
+}
+
+// usersTable returns the table of the polled connections
+// grouped by the user they authenticated as.
+func (v *View) usersTable(stats *top.Stats) *Table {
+	header := []string{"ACCOUNT", "USER", "CONNS", "SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S"}
+	widths := []int{10, 15, 6, 6, 10, 10, 10, 10, 10}
+
+	table := NewTable(header, widths)
+	for i, user := range stats.UserConns {
+		account := user.Account
+		if account == "" {
+			account = "-"
+		}
+		name := user.User
+		if name == "" {
+			name = "-"
+		}
+
+		// Groups are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: account},
+			Cell{Text: name},
+			Cell{Text: fmt.Sprintf("%d", user.Conns)},
+			Cell{Text: fmt.Sprintf("%d", user.Subs)},
+			Cell{Text: top.Psize(int64(user.Pending))},
+			Cell{Text: top.Psize(user.OutMsgs)},
+			Cell{Text: top.Psize(user.InMsgs)},
+			Cell{Text: top.Psize(user.OutBytes)},
+			Cell{Text: top.Psize(user.InBytes)},
+			Cell{Text: fmt.Sprintf("%.1f", user.Rates.OutMsgsRate)},
+			Cell{Text: fmt.Sprintf("%.1f", user.Rates.InMsgsRate)},
+			Cell{Text: top.Psize(int64(user.Rates.OutBytesRate))},
+			Cell{Text: top.Psize(int64(user.Rates.InBytesRate))},
+		)
+	}
+
+	return table
+}
+
+// routesTable returns the table of the routes to the other servers
+// of the cluster, so that their backpressure can be spotted like
+// the one of slow clients.
+func (v *View) routesTable(stats *top.Stats) *Table {
+	header := []string{"RID", "REMOTE ID", "HOST", "SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM"}
+	widths := []int{6, 22, DEFAULT_HOST_PADDING_SIZE, 6, 10, 10, 10, 10, 10}
+
+	table := NewTable(header, widths)
+	if stats.Routez == nil {
+		return table
+	}
+	for _, route := range stats.Routez.Routes {
+		table.AddRow(route.Rid,
+			Cell{Text: fmt.Sprintf("%d", route.Rid)},
+			Cell{Text: route.RemoteID},
+			Cell{Text: v.hostname(route.IP, route.Port)},
+			Cell{Text: fmt.Sprintf("%d", route.NumSubs)},
+			Cell{Text: top.Psize(int64(route.Pending))},
+			Cell{Text: top.Psize(route.OutMsgs)},
+			Cell{Text: top.Psize(route.InMsgs)},
+			Cell{Text: top.Psize(route.OutBytes)},
+			Cell{Text: top.Psize(route.InBytes)},
+		)
+	}
+
+	return table
+}
+
+// subjectsTable returns the table of the subjects subscribed to,
+// as listed by newer servers, with their number of subscriptions.
+func (v *View) subjectsTable(stats *top.Stats) *Table {
+	header := []string{"SUBJECT", "SUBS", "QUEUE_SUBS", "MSGS"}
+	widths := []int{40, 6, 10, 10}
+
+	table := NewTable(header, widths)
+	for i, subject := range stats.SubjectSubs {
+		if !top.SubjectMatches(v.Subject, subject.Subject) {
+			continue
+		}
+
+		// Subjects are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: subject.Subject},
+			Cell{Text: fmt.Sprintf("%d", subject.Subs)},
+			Cell{Text: fmt.Sprintf("%d", subject.QueueSubs)},
+			Cell{Text: top.Psize(subject.Msgs)},
+		)
+	}
+
+	return table
+}
+
+// gatewaysTable returns the table of the remote gateways of the
+// super-cluster, with the traffic of the connections with each.
+func (v *View) gatewaysTable(stats *top.Stats) *Table {
+	header := []string{"GATEWAY", "OUT", "IN", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S"}
+	widths := []int{15, 4, 4, 10, 10, 10, 10, 10}
+
+	table := NewTable(header, widths)
+	for i, gw := range stats.Gateways {
+		// Gateways are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: gw.Name},
+			Cell{Text: fmt.Sprintf("%d", gw.Outbound)},
+			Cell{Text: fmt.Sprintf("%d", gw.Inbound)},
+			Cell{Text: top.Psize(int64(gw.Pending))},
+			Cell{Text: top.Psize(gw.OutMsgs)},
+			Cell{Text: top.Psize(gw.InMsgs)},
+			Cell{Text: top.Psize(gw.OutBytes)},
+			Cell{Text: top.Psize(gw.InBytes)},
+			Cell{Text: fmt.Sprintf("%.1f", gw.Rates.OutMsgsRate)},
+			Cell{Text: fmt.Sprintf("%.1f", gw.Rates.InMsgsRate)},
+			Cell{Text: top.Psize(int64(gw.Rates.OutBytesRate))},
+			Cell{Text: top.Psize(int64(gw.Rates.InBytesRate))},
+		)
+	}
+
+	return table
+}
+
+// accountStatsTable returns the table of the accounts with connections,
+// along with their totals and rates.
+func (v *View) accountStatsTable(stats *top.Stats) *Table {
+	header := []string{"ACCOUNT", "CONNS", "LEAFS", "SUBS", "SLOW", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S"}
+	widths := []int{15, 5, 5, 6, 5, 10, 10, 10, 10}
+
+	table := NewTable(header, widths)
+	for i, acc := range stats.AccountTraffic {
+		// Accounts are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: acc.Account},
+			Cell{Text: fmt.Sprintf("%d", acc.Conns)},
+			Cell{Text: fmt.Sprintf("%d", acc.LeafNodes)},
+			Cell{Text: fmt.Sprintf("%d", acc.NumSubs)},
+			Cell{Text: fmt.Sprintf("%d", acc.SlowConsumers)},
+			Cell{Text: top.Psize(acc.Sent.Msgs)},
+			Cell{Text: top.Psize(acc.Received.Msgs)},
+			Cell{Text: top.Psize(acc.Sent.Bytes)},
+			Cell{Text: top.Psize(acc.Received.Bytes)},
+			Cell{Text: fmt.Sprintf("%.1f", acc.Rates.OutMsgsRate)},
+			Cell{Text: fmt.Sprintf("%.1f", acc.Rates.InMsgsRate)},
+			Cell{Text: top.Psize(int64(acc.Rates.OutBytesRate))},
+			Cell{Text: top.Psize(int64(acc.Rates.InBytesRate))},
+		)
+	}
+
+	return table
+}
+
+// leafsTable returns the table of the leafnode connections,
+// sorted like the connections when the option applies to them.
+func (v *View) leafsTable(stats *top.Stats) *Table {
+	header := []string{"HOST", "NAME", "ACCOUNT", "SUBS", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S", "RTT"}
+	widths := []int{DEFAULT_HOST_PADDING_SIZE, 0, 10, 6, 10, 10, 10, 10, 0, 0, 0, 0, 10}
+
+	table := NewTable(header, widths)
+	for i, leaf := range stats.LeafTraffic {
+		// Leafnodes are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: v.hostname(leaf.IP, leaf.Port)},
+			Cell{Text: leaf.Name},
+			Cell{Text: leaf.Account},
+			Cell{Text: fmt.Sprintf("%d", leaf.NumSubs)},
+			Cell{Text: top.Psize(leaf.OutMsgs)},
+			Cell{Text: top.Psize(leaf.InMsgs)},
+			Cell{Text: top.Psize(leaf.OutBytes)},
+			Cell{Text: top.Psize(leaf.InBytes)},
+			Cell{Text: fmt.Sprintf("%.1f", leaf.Rates.OutMsgsRate)},
+			Cell{Text: fmt.Sprintf("%.1f", leaf.Rates.InMsgsRate)},
+			Cell{Text: top.Psize(int64(leaf.Rates.OutBytesRate))},
+			Cell{Text: top.Psize(int64(leaf.Rates.InBytesRate))},
+			Cell{Text: leaf.RTT},
+		)
+	}
+
+	return table
+}
+
+// jetstreamTable returns the table of the accounts using JetStream,
+// summing the streams, consumers and messages of each.
+func (v *View) jetstreamTable(stats *top.Stats) *Table {
+	header := []string{"ACCOUNT", "STREAMS", "CONSUMERS", "MSGS", "BYTES", "MEMORY", "STORAGE", "API", "API_ERRORS"}
+	widths := []int{15, 7, 9, 10, 10, 10, 10, 10, 10}
+
+	table := NewTable(header, widths)
+	if stats.Jsz == nil {
+		return table
+	}
+	for i, acc := range stats.Jsz.AccountDetails {
+		var consumers int
+		var msgs, bytes uint64
+		for _, stream := range acc.Streams {
+			consumers += stream.State.Consumers
+			msgs += stream.State.Msgs
+			bytes += stream.State.Bytes
+		}
+
+		// Accounts are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: acc.Name},
+			Cell{Text: fmt.Sprintf("%d", len(acc.Streams))},
+			Cell{Text: fmt.Sprintf("%d", consumers)},
+			Cell{Text: top.Psize(int64(msgs))},
+			Cell{Text: top.Psize(int64(bytes))},
+			Cell{Text: top.Psize(int64(acc.Memory))},
+			Cell{Text: top.Psize(int64(acc.Store))},
+			Cell{Text: top.Psize(int64(acc.API.Total))},
+			Cell{Text: top.Psize(int64(acc.API.Errors))},
+		)
+	}
+
+	return table
+}
+
+// DrillDown lists the details of the selected row of the table when
+// there are any, which are the subscriptions of a connection in the
+// connections table, the streams of an account and then the consumers
+// of a stream in the JetStream view, the detail of an account in the
+// accounts view, or the subscriptions of the remote of a leafnode in the
+// leafnodes view, and returns whether it did.
+func (v *View) DrillDown(stats *top.Stats, table *Table) bool {
+	i := table.SelectedIndex()
+	if v.ListingConns() {
+		// Rows of the connections table are their cids
+		if v.ConnDetail != 0 || v.GroupByUser || i < 0 {
+			return false
+		}
+		v.ConnDetail = table.Selected
+		return true
+	}
+	if v.ListLeafs {
+		if v.LeafDetail != "" || i < 0 || i >= len(stats.LeafTraffic) {
+			return false
+		}
+		v.LeafDetail = stats.LeafTraffic[i].Addr()
+		return true
+	}
+	if v.ListAccounts {
+		if v.AccountDetail != "" || stats.Accountz == nil || i < 0 || i >= len(stats.Accountz.Accounts) {
+			return false
+		}
+		v.AccountDetail = stats.Accountz.Accounts[i]
+		return true
+	}
+	if !v.ListJetStream || v.JetStreamStream != "" || stats.Jsz == nil || i < 0 {
+		return false
+	}
+	if v.JetStreamAccount == "" {
+		if i >= len(stats.Jsz.AccountDetails) {
+			return false
+		}
+		v.JetStreamAccount = stats.Jsz.AccountDetails[i].Name
+		return true
+	}
+	acc := v.jetstreamAccount(stats)
+	if acc == nil || i >= len(acc.Streams) {
+		return false
+	}
+	v.JetStreamStream = acc.Streams[i].Name
+	return true
+}
+
+// DrillUp goes back to the table the details were selected
+// from, and returns whether there was one.
+func (v *View) DrillUp() bool {
+	switch {
+	case v.ConnDetail != 0:
+		v.ConnDetail = 0
+	case v.AccountDetail != "":
+		v.AccountDetail = ""
+	case v.LeafDetail != "":
+		v.LeafDetail = ""
+	case v.JetStreamStream != "":
+		v.JetStreamStream = ""
+	case v.JetStreamAccount != "":
+		v.JetStreamAccount = ""
+	default:
+		return false
+	}
+	return true
+}
+
+// leafDetail returns the leafnode connection from the remote at
+// the address, or nil when it is no longer connected.
+func leafDetail(addr string, stats *top.Stats) *top.LeafTraffic {
+	for _, leaf := range stats.LeafTraffic {
+		if leaf.Addr() == addr {
+			return leaf
+		}
+	}
+	return nil
+}
+
+// leafDetailText returns the header of the subscriptions
+// of the selected leafnode connection.
+func leafDetailText(addr string, stats *top.Stats) string {
+	leaf := leafDetail(addr, stats)
+	if leaf == nil {
+		return fmt.Sprintf("  Leafnode %s: not found, it may have disconnected", addr)
+	}
+	text := fmt.Sprintf("  Leafnode %s", addr)
+	if leaf.Name != "" {
+		text += fmt.Sprintf(" (%s)", leaf.Name)
+	}
+	text += fmt.Sprintf(": %d subscriptions of the remote in account %s", leaf.NumSubs, leaf.Account)
+	return text
+}
+
+// leafSubsTable returns the table of the subscriptions of the remote
+// of a leafnode connection, which messages are sent to it for.
+func (v *View) leafSubsTable(stats *top.Stats) *Table {
+	table := NewTable([]string{"SUBJECT"}, []int{0})
+	leaf := leafDetail(v.LeafDetail, stats)
+	if leaf == nil {
+		return table
+	}
+	for i, sub := range leaf.Subs {
+		// Subscriptions are selected by their position
+		table.AddRow(uint64(i+1), Cell{Text: sub})
+	}
+
+	return table
+}
+
+// connDetailText returns the header of the subscriptions of the
+// selected connection, noting when they are not reported.
+func connDetailText(cid uint64, stats *top.Stats) string {
+	if len(stats.Connz.Conns) == 0 {
+		return fmt.Sprintf("  Connection %d: not found, it may have been closed", cid)
+	}
+	conn := stats.Connz.Conns[0]
+	text := fmt.Sprintf("  Connection %d", cid)
+	if conn.Name != "" {
+		text += fmt.Sprintf(" (%s)", conn.Name)
+	}
+	text += fmt.Sprintf(": %d subscriptions, %s pending", conn.NumSubs, top.Psize(int64(conn.Pending)))
+	if conn.NumSubs > 0 && len(stats.SubTraffic) == 0 {
+		text += " (subscriptions not detailed by this server)"
+	}
+
+	// The tables only show how long ago these were
+	text += fmt.Sprintf("\n  Started: %s  Last activity: %s", exactTime(conn.Start), exactTime(conn.LastActivity))
+	return text
+}
+
+// exactTime formats a timestamp of a connection down to the millisecond,
+// in the time zone reported by the server.
+func exactTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05.000 MST")
+}
+
+// connSubsTable returns the table of the subscriptions of the selected
+// connection with the messages delivered to each and their rate, along
+// with their estimated share of the pending bytes of the connection.
+func (v *View) connSubsTable(stats *top.Stats) *Table {
+	header := []string{"SUBJECT", "QUEUE", "SID", "MSGS", "MSGS/S", "SHARE", "EST_PENDING", "MAX"}
+	widths := []int{0, 10, 6, 10, 10, 6, 11, 0}
+
+	table := NewTable(header, widths)
+	for i, sub := range stats.SubTraffic {
+		var max string
+		if sub.Max > 0 {
+			max = fmt.Sprintf("%d", sub.Max)
+		}
+
+		// Subscriptions are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: sub.Subject},
+			Cell{Text: sub.Queue},
+			Cell{Text: sub.Sid},
+			Cell{Text: top.Psize(sub.Msgs)},
+			Cell{Text: fmt.Sprintf("%.1f", sub.MsgsRate)},
+			Cell{Text: fmt.Sprintf("%.0f%%", sub.Share*100)},
+			Cell{Text: top.Psize(sub.Pending)},
+			Cell{Text: max},
+		)
+	}
+
+	return table
+}
+
+// alertsTable returns the table of the latest alert events of the
+// session, along with whether each alert is still firing.
+func (v *View) alertsTable(stats *top.Stats) *Table {
+	header := []string{"TIME", "EVENT", "STATE", "CONDITION", "MESSAGE"}
+	widths := []int{8, 8, 8, 15, 0}
+
+	table := NewTable(header, widths)
+	if v.Engine.Session == nil {
+		return table
+	}
+	firing := make(map[string]bool)
+	for _, alert := range stats.Alerts {
+		firing[alert.Condition+"/"+alert.Target] = true
+	}
+	for i, event := range v.Engine.Session.AlertHistory() {
+		kind := "fired"
+		if event.Resolved {
+			kind = "resolved"
+		}
+		state := Cell{Text: "resolved"}
+		if firing[event.Alert.Condition+"/"+event.Alert.Target] {
+			state = Cell{Text: "firing", Fg: ui.ColorRed}
+		}
+
+		// Events are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: event.Time.Format("15:04:05")},
+			Cell{Text: kind},
+			state,
+			Cell{Text: event.Alert.Condition},
+			Cell{Text: event.Alert.Message},
+		)
+	}
+
+	return table
+}
+
+// accountDetail returns the detail of the selected account,
+// once polled.
+func (v *View) accountDetail(stats *top.Stats) *top.AccountInfo {
+	if v.AccountDetail == "" || stats.Accountz == nil {
+		return nil
+	}
+	if acc := stats.Accountz.Account; acc != nil && acc.Name == v.AccountDetail {
+		return acc
+	}
+	return nil
+}
+
+// accountsTable returns the table of the accounts of the server.
+func (v *View) accountsTable(stats *top.Stats) *Table {
+	header := []string{"ACCOUNT", "SYSTEM"}
+	widths := []int{DEFAULT_HOST_PADDING_SIZE, 0}
+
+	table := NewTable(header, widths)
+	if stats.Accountz == nil {
+		return table
+	}
+	for i, name := range stats.Accountz.Accounts {
+		var system string
+		if name == stats.Accountz.SystemAccount {
+			system = "yes"
+		}
+
+		// Accounts are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: name},
+			Cell{Text: system},
+		)
+	}
+
+	return table
+}
+
+// accountTable returns the detail of the selected account as a table
+// of fields, along with its limits when defined by a JWT and a row for
+// each of its imports and exports.
+func (v *View) accountTable(stats *top.Stats) *Table {
+	header := []string{"FIELD", "VALUE"}
+	widths := []int{15, 0}
+
+	table := NewTable(header, widths)
+	acc := v.accountDetail(stats)
+	if acc == nil {
+		return table
+	}
+
+	fields := [][2]string{
+		{"Account", acc.Name},
+		{"Name", acc.NameTag},
+		{"Issuer", acc.IssuerKey},
+		{"System", yesNo(acc.IsSystem)},
+		{"Expired", yesNo(acc.Expired)},
+		{"JetStream", yesNo(acc.JetStream)},
+		{"Connections", fmt.Sprintf("%d", acc.Clients)},
+		{"Leafnodes", fmt.Sprintf("%d", acc.Leafs)},
+		{"Subscriptions", fmt.Sprintf("%d", acc.Subs)},
+	}
+	if acc.Claims != nil {
+		limits := acc.Claims.Nats.Limits
+		fields = append(fields,
+			[2]string{"Max Conns", limit(limits.Conn, fmt.Sprintf("%d", limits.Conn))},
+			[2]string{"Max Leafnodes", limit(limits.Leaf, fmt.Sprintf("%d", limits.Leaf))},
+			[2]string{"Max Subs", limit(limits.Subs, fmt.Sprintf("%d", limits.Subs))},
+			[2]string{"Max Data", limit(limits.Data, top.Psize(limits.Data))},
+			[2]string{"Max Payload", limit(limits.Payload, top.Psize(limits.Payload))},
+			[2]string{"Max Imports", limit(limits.Imports, fmt.Sprintf("%d", limits.Imports))},
+			[2]string{"Max Exports", limit(limits.Exports, fmt.Sprintf("%d", limits.Exports))},
+		)
+	}
+	for _, imp := range acc.Imports {
+		value := fmt.Sprintf("%s %s from %s", imp.Type, imp.Subject, imp.Account)
+		if imp.Invalid {
+			value += " (invalid)"
+		}
+		fields = append(fields, [2]string{"Import", value})
+	}
+	for _, exp := range acc.Exports {
+		value := fmt.Sprintf("%s %s to ", exp.Type, exp.Subject)
+		if len(exp.Approved) > 0 {
+			value += strings.Join(exp.Approved, ", ")
+		} else {
+			value += "any account"
+		}
+		fields = append(fields, [2]string{"Export", value})
+	}
+
+	for i, field := range fields {
+		// Fields are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: field[0]},
+			Cell{Text: field[1]},
+		)
+	}
+
+	return table
+}
+
+// yesNo returns whether a flag of an account is set.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// limit returns a formatted limit of an account, which
+// are unlimited when negative.
+func limit(n int64, formatted string) string {
+	if n < 0 {
+		return "unlimited"
+	}
+	return formatted
+}
+
+// jetstreamAccount returns the JetStream usage of the
+// account whose streams are listed, if any.
+func (v *View) jetstreamAccount(stats *top.Stats) *top.JSAccountDetail {
+	if v.JetStreamAccount == "" || stats.Jsz == nil {
+		return nil
+	}
+	for _, acc := range stats.Jsz.AccountDetails {
+		if acc.Name == v.JetStreamAccount {
+			return acc
+		}
+	}
+	return nil
+}
+
+// jetstreamStream returns the stream whose consumers are listed, if any.
+func (v *View) jetstreamStream(stats *top.Stats) *top.StreamDetail {
+	acc := v.jetstreamAccount(stats)
+	if acc == nil || v.JetStreamStream == "" {
+		return nil
+	}
+	for _, stream := range acc.Streams {
+		if stream.Name == v.JetStreamStream {
+			return stream
+		}
+	}
+	return nil
+}
+
+// consumersTable returns the table of the consumers of a stream along
+// with their lag, the messages of the stream left to deliver to them.
+func (v *View) consumersTable(stats *top.Stats) *Table {
+	header := []string{"CONSUMER", "DELIVERED", "ACK_FLOOR", "ACK_PENDING", "REDELIVERED", "WAITING", "LAG"}
+	widths := []int{15, 10, 10, 11, 11, 7, 0}
+
+	table := NewTable(header, widths)
+	stream := v.jetstreamStream(stats)
+	if stream == nil {
+		return table
+	}
+	for i, consumer := range stream.Consumers {
+		// Acks pending past the threshold of the alert stand out
+		ackPending := Cell{Text: fmt.Sprintf("%d", consumer.NumAckPending)}
+		if pct := consumer.AckPendingPct(); v.Engine.AckPending > 0 && pct > 0 && pct >= v.Engine.AckPending {
+			ackPending.Fg = ui.ColorRed
+		}
+
+		// Consumers are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: consumer.Name},
+			Cell{Text: fmt.Sprintf("%d", consumer.Delivered.Stream)},
+			Cell{Text: fmt.Sprintf("%d", consumer.AckFloor.Stream)},
+			ackPending,
+			Cell{Text: fmt.Sprintf("%d", consumer.NumRedelivered)},
+			Cell{Text: fmt.Sprintf("%d", consumer.NumWaiting)},
+			Cell{Text: fmt.Sprintf("%d", consumer.NumPending)},
+		)
+	}
+
+	return table
+}
+
+// streamsTable returns the table of the streams of an account,
+// along with their sequences, replicas and leader.
+func (v *View) streamsTable(stats *top.Stats) *Table {
+	header := []string{"STREAM", "MSGS", "BYTES", "FIRST_SEQ", "LAST_SEQ", "CONSUMERS", "REPLICAS", "LEADER"}
+	widths := []int{15, 10, 10, 10, 10, 9, 8, 0}
+
+	table := NewTable(header, widths)
+	acc := v.jetstreamAccount(stats)
+	if acc == nil {
+		return table
+	}
+	for i, stream := range acc.Streams {
+		// Streams have as many replicas as configured, of
+		// which the leader and those caught up are current.
+		replicas := "1"
+		var leader string
+		if cluster := stream.Cluster; cluster != nil {
+			current := 1
+			for _, peer := range cluster.Replicas {
+				if peer.Current {
+					current++
+				}
+			}
+			replicas = fmt.Sprintf("%d/%d", current, len(cluster.Replicas)+1)
+			leader = cluster.Leader
+		} else if stream.Config != nil && stream.Config.Replicas > 0 {
+			replicas = fmt.Sprintf("%d", stream.Config.Replicas)
+		}
+
+		// Streams are selected by their position
+		table.AddRow(uint64(i+1),
+			Cell{Text: stream.Name},
+			Cell{Text: top.Psize(int64(stream.State.Msgs))},
+			Cell{Text: top.Psize(int64(stream.State.Bytes))},
+			Cell{Text: fmt.Sprintf("%d", stream.State.FirstSeq)},
+			Cell{Text: fmt.Sprintf("%d", stream.State.LastSeq)},
+			Cell{Text: fmt.Sprintf("%d", stream.State.Consumers)},
+			Cell{Text: replicas},
+			Cell{Text: leader},
+		)
+	}
+
+	return table
+}
+
+// hostname returns the address of a client, which is looked up
+// when enabled and memoized for subsequent polls.
+func (v *View) hostname(ip string, port int) string {
+	if !v.LookupDNS {
+		return fmt.Sprintf("%s:%d", ip, port)
+	}
+
+	if addr, present := v.resolvedHosts[ip]; present {
+		return addr
+	}
+
+	hostname := fmt.Sprintf("%s:%d", ip, port)
+	addrs, err := net.LookupAddr(ip)
+	if err == nil && len(addrs) > 0 && len(addrs[0]) > 0 {
+		hostname = addrs[0]
+	}
+	// Otherwise just continue to use ip:port as resolved host
+	// can be an empty string even though there were no errors.
+	v.resolvedHosts[ip] = hostname
+
+	return hostname
+}
+
+// subsLine returns the subscriptions listed under a connection,
+// if they are being displayed and any matches the subject.
+func (v *View) subsLine(subs []string) string {
+	if !v.DisplaySubs {
+		return ""
+	}
+
+	var matching []string
+	for _, sub := range subs {
+		if top.SubjectMatches(v.Subject, sub) {
+			matching = append(matching, sub)
+		}
+	}
+	if len(matching) == 0 {
+		return ""
+	}
+	return generateSubsLine(matching)
+}
+
+// rttHistogram returns a bar for each of the buckets the round trip
+// times of the connections were counted in, scaled to the total.
+func rttHistogram(counts []int) string {
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+	text := fmt.Sprintf("RTT: %d connections", total)
+	if total == 0 {
+		return text + " (not reported by this server)"
+	}
+	for i, n := range counts {
+		label := fmt.Sprintf(">= %s", top.RTTBuckets[len(top.RTTBuckets)-1])
+		if i < len(top.RTTBuckets) {
+			label = fmt.Sprintf("< %s", top.RTTBuckets[i])
+		}
+		cells := n * DEFAULT_GAUGE_WIDTH / total
+		bar := strings.Repeat("#", cells) + strings.Repeat(".", DEFAULT_GAUGE_WIDTH-cells)
+		text += fmt.Sprintf("\n  %-8s [%s]  %d (%.1f%%)", label, bar, n, float64(n)/float64(total)*100)
+	}
+	return text
+}
+
+// gauge returns a bar with the used and reserved resources against their
+// limit, noting when more than the limit has been reserved.
+func gauge(used, reserved uint64, limit int64) string {
+	if limit <= 0 {
+		return "[" + strings.Repeat(".", DEFAULT_GAUGE_WIDTH) + "]  no limit"
+	}
+
+	cells := func(n uint64) int {
+		c := int(float64(n) / float64(limit) * DEFAULT_GAUGE_WIDTH)
+		if c > DEFAULT_GAUGE_WIDTH {
+			c = DEFAULT_GAUGE_WIDTH
+		}
+		return c
+	}
+	usedCells := cells(used)
+	reservedCells := cells(reserved) - usedCells
+	if reservedCells < 0 {
+		reservedCells = 0
+	}
+	bar := strings.Repeat("#", usedCells) + strings.Repeat("=", reservedCells) +
+		strings.Repeat(".", DEFAULT_GAUGE_WIDTH-usedCells-reservedCells)
+
+	pct := func(n uint64) float64 {
+		return float64(n) / float64(limit) * 100
+	}
+	text := fmt.Sprintf("[%s]  Used: %s (%.1f%%)  Reserved: %s (%.1f%%)",
+		bar, top.Psize(int64(used)), pct(used), top.Psize(int64(reserved)), pct(reserved))
+	if reserved > uint64(limit) {
+		text += "  over-committed"
+	}
+	return text
+}
+
+// generateSubsLine returns the line listing the subjects of a
+// connection, showing at most DEFAULT_MAX_SUBS_DISPLAYED of them.
+func generateSubsLine(subs []string) string {
+	shown := subs
+	if len(shown) > DEFAULT_MAX_SUBS_DISPLAYED {
+		shown = shown[:DEFAULT_MAX_SUBS_DISPLAYED]
+	}
+
+	line := DEFAULT_PADDING + DEFAULT_PADDING + "└ " + strings.Join(shown, ", ")
+	if more := len(subs) - len(shown); more > 0 {
+		line += fmt.Sprintf(" (+%d more)", more)
+	}
+
+	return line + "\n"
+}
@@ -0,0 +1,638 @@
+package view
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+	ui "gopkg.in/gizak/termui.v1"
+)
+
+var update = flag.Bool("update", false, "Update the golden files of the views.")
+
+// checkGolden compares the text of a view with the golden file
+// in testdata, which is written instead when running with -update.
+func checkGolden(t *testing.T, name string, text string) {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		err := ioutil.WriteFile(path, []byte(text), 0644)
+		if err != nil {
+			t.Fatalf("Could not update golden file: %v", err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read golden file: %v", err)
+	}
+	if text != string(expected) {
+		t.Fatalf("Wrong %s view. expected:\n%s\ngot:\n%s", name, expected, text)
+	}
+}
+
+func testStats() *top.Stats {
+	lastActivity := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	return &top.Stats{
+		Varz: &server.Varz{
+			Info:          &server.Info{Version: "0.9.2"},
+			Uptime:        "1h2m3s",
+			Now:           lastActivity.Add(45 * time.Second),
+			CPU:           12.5,
+			Mem:           12 * 1024 * 1024,
+			InMsgs:        1500,
+			OutMsgs:       3000,
+			InBytes:       150000,
+			OutBytes:      300000,
+			SlowConsumers: 1,
+			Subscriptions: 2,
+			Routes:        1,
+		},
+		ExtVarz: &top.ExtVarz{
+			Leafs:   3,
+			Gateway: &top.GatewayVarz{Name: "east", Gateways: []top.RemoteGatewayVarz{{Name: "west"}}},
+		},
+		Connz: &server.Connz{
+			NumConns: 2,
+			Conns: []server.ConnInfo{
+				{
+					Cid: 1, IP: "127.0.0.1", Port: 50001, Name: "publisher",
+					NumSubs: 0, Pending: 0, InMsgs: 1500, InBytes: 150000,
+					Lang: "go", Version: "1.2.2", Uptime: "1d2h3m4s", LastActivity: lastActivity,
+				},
+				{
+					Cid: 2, IP: "127.0.0.1", Port: 50002, Name: "worker",
+					NumSubs: 2, Pending: 2048, OutMsgs: 3000, OutBytes: 300000,
+					Lang: "go", Version: "1.2.2", Uptime: "59m", LastActivity: lastActivity,
+					Subs: []string{"orders.>", "events.*"},
+				},
+			},
+		},
+		ExtConnz: &top.ExtConnz{
+			Conns: []top.ExtConnInfo{{Account: "A"}, {Account: "B"}},
+		},
+		Subsz: &server.Subsz{
+			SublistStats: &server.SublistStats{NumSubs: 2, NumCache: 4, CacheHitRate: 0.5, MaxFanout: 1, AvgFanout: 1},
+		},
+		Rates: &top.Rates{
+			InMsgsRate:   10,
+			OutMsgsRate:  20,
+			InBytesRate:  1024,
+			OutBytesRate: 2048,
+		},
+		Alerts: []*top.Alert{
+			{Condition: "route_missing", Message: "1 of 2 routes missing", Since: lastActivity},
+		},
+		AccountConns: []*top.AccountConns{
+			{Account: "A", Conns: 1, Delta: 1},
+			{Account: "B", Conns: 1},
+		},
+		Error: fmt.Errorf(""),
+	}
+}
+
+func TestParagraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(v *View, stats *top.Stats)
+	}{
+		{"top", func(v *View, stats *top.Stats) {}},
+		{"subs", func(v *View, stats *top.Stats) {
+			v.DisplaySubs = true
+			v.Subject = "orders.*"
+		}},
+		{"panels", func(v *View, stats *top.Stats) {
+			v.DisplaySublist = true
+			v.DisplayAccounts = true
+			v.DisplayRoutes = true
+			stats.SubsChurn = &top.SubsChurn{AddedRate: 12.5, RemovedRate: 3}
+			stats.RouteSubs = []*top.RouteSubs{
+				{Rid: 1, Remote: "10.0.0.2:6222", Subs: 1200, Delta: 1100},
+			}
+		}},
+		{"users", func(v *View, stats *top.Stats) {
+			v.DisplayUsers = true
+			stats.Connz.Conns[1].AuthorizedUser = "worker-user"
+		}},
+		{"group", func(v *View, stats *top.Stats) {
+			v.GroupByUser = true
+			stats.UserConns = []*top.UserConns{
+				{Account: "A", User: "worker", Conns: 2, Subs: 4, OutMsgs: 3000, Rates: &top.Rates{OutMsgsRate: 20}},
+				{Conns: 1, InMsgs: 1500, Rates: &top.Rates{InMsgsRate: 10}},
+			}
+		}},
+		{"jetstream", func(v *View, stats *top.Stats) {
+			stats.Jsz = &top.Jsz{
+				Config:         &top.JetStreamConfig{MaxMemory: 1024 * 1024, MaxStore: 1024 * 1024 * 1024},
+				Memory:         256 * 1024,
+				ReservedMemory: 512 * 1024,
+				Store:          100 * 1024 * 1024,
+				ReservedStore:  2 * 1024 * 1024 * 1024,
+			}
+		}},
+		{"cluster", func(v *View, stats *top.Stats) {
+			stats.Options.Cluster = "east"
+		}},
+		{"failover", func(v *View, stats *top.Stats) {
+			stats.Failover = "failed over from http://nats-1:8222 to http://nats-2:8222 at 12:00:00"
+		}},
+		{"mark", func(v *View, stats *top.Stats) {
+			stats.Mark = &top.Mark{Time: time.Date(2016, 10, 1, 12, 30, 0, 0, time.UTC)}
+		}},
+		{"routez", func(v *View, stats *top.Stats) {
+			v.ListRoutes = true
+			stats.Routez = &server.Routez{Routes: []*server.RouteInfo{
+				{Rid: 7, RemoteID: "NBRZJDPCR3SJEWDKGNBCFQJPSYFEFLFTLYKGOC7QXW6G2WWY", IP: "10.0.0.2", Port: 6222,
+					NumSubs: 1200, Pending: 64 * 1024, InMsgs: 1500, OutMsgs: 3000, InBytes: 150000, OutBytes: 300000},
+			}}
+		}},
+		{"subjects", func(v *View, stats *top.Stats) {
+			v.ListSubjects = true
+			v.Subject = "orders.>"
+			stats.ExtSubsz = &top.ExtSubsz{Total: 10, Subs: make([]top.SubDetails, 4)}
+			stats.SubjectSubs = []*top.SubjectSubs{
+				{Subject: "orders.new", Subs: 3, QueueSubs: 2, Msgs: 4500},
+				{Subject: "events.*", Subs: 1, Msgs: 10},
+			}
+		}},
+		{"gatewayz", func(v *View, stats *top.Stats) {
+			v.ListGateways = true
+			stats.Gatewayz = &top.Gatewayz{Name: "east"}
+			stats.Gateways = []*top.GatewayTraffic{
+				{Name: "west", Outbound: 1, Inbound: 2, Pending: 2048, InMsgs: 1500, OutMsgs: 3000,
+					InBytes: 150000, OutBytes: 300000, Rates: &top.Rates{OutMsgsRate: 20, InMsgsRate: 10}},
+			}
+		}},
+		{"accstatz", func(v *View, stats *top.Stats) {
+			v.ListAccountStats = true
+			stats.Accstatz = &top.Accstatz{}
+			stats.AccountTraffic = []*top.AccountTraffic{
+				{AccountStat: &top.AccountStat{Account: "A", Conns: 3, LeafNodes: 1, NumSubs: 40, SlowConsumers: 1,
+					Sent: top.DataStats{Msgs: 3000, Bytes: 300000}, Received: top.DataStats{Msgs: 1500, Bytes: 150000}},
+					Rates: &top.Rates{OutMsgsRate: 20, InMsgsRate: 10, OutBytesRate: 2048, InBytesRate: 1024}},
+			}
+		}},
+		{"leafz", func(v *View, stats *top.Stats) {
+			v.ListLeafs = true
+			leaf := &top.LeafInfo{Name: "edge-1", Account: "A", IP: "10.0.1.5", Port: 7422, RTT: "12ms",
+				NumSubs: 40, InMsgs: 1500, OutMsgs: 3000, InBytes: 150000, OutBytes: 300000}
+			stats.Leafz = &top.Leafz{Leafs: []*top.LeafInfo{leaf}}
+			stats.LeafTraffic = []*top.LeafTraffic{{LeafInfo: leaf,
+				Rates: &top.Rates{InMsgsRate: 5, OutMsgsRate: 10, InBytesRate: 500, OutBytesRate: 1000}}}
+		}},
+		{"leafz_detail", func(v *View, stats *top.Stats) {
+			v.ListLeafs = true
+			v.LeafDetail = "10.0.1.5:7422"
+			leaf := &top.LeafInfo{Name: "edge-1", Account: "A", IP: "10.0.1.5", Port: 7422,
+				NumSubs: 2, Subs: []string{"orders.>", "_INBOX.edge.*"}}
+			stats.Leafz = &top.Leafz{Leafs: []*top.LeafInfo{leaf}}
+			stats.LeafTraffic = []*top.LeafTraffic{{LeafInfo: leaf, Rates: &top.Rates{}}}
+		}},
+		{"jetstream_accounts", func(v *View, stats *top.Stats) {
+			v.ListJetStream = true
+			stats.Jsz = &top.Jsz{
+				Config:    &top.JetStreamConfig{MaxMemory: 1024 * 1024, MaxStore: 1024 * 1024 * 1024},
+				Memory:    256 * 1024,
+				Store:     100 * 1024 * 1024,
+				Streams:   2,
+				Consumers: 3,
+				Messages:  1500,
+				Bytes:     150000,
+				API:       top.JetStreamAPIStats{Total: 200, Errors: 5},
+				AccountDetails: []*top.JSAccountDetail{
+					{Name: "A", Memory: 256 * 1024, Store: 100 * 1024 * 1024, API: top.JetStreamAPIStats{Total: 200, Errors: 5},
+						Streams: []*top.StreamDetail{
+							{Name: "ORDERS", State: top.StreamState{Msgs: 1000, Bytes: 100000, Consumers: 2}},
+							{Name: "EVENTS", State: top.StreamState{Msgs: 500, Bytes: 50000, Consumers: 1}},
+						}},
+				},
+			}
+		}},
+		{"jetstream_streams", func(v *View, stats *top.Stats) {
+			v.ListJetStream = true
+			v.JetStreamAccount = "A"
+			stats.Jsz = &top.Jsz{
+				Config:    &top.JetStreamConfig{MaxMemory: 1024 * 1024, MaxStore: 1024 * 1024 * 1024},
+				Streams:   2,
+				Consumers: 3,
+				AccountDetails: []*top.JSAccountDetail{
+					{Name: "A", Streams: []*top.StreamDetail{
+						{Name: "ORDERS", State: top.StreamState{Msgs: 1000, Bytes: 100000, FirstSeq: 11, LastSeq: 1010, Consumers: 2},
+							Config: &top.StreamConfig{Replicas: 3},
+							Cluster: &top.StreamClusterInfo{Leader: "n1", Replicas: []*top.PeerInfo{
+								{Name: "n2", Current: true},
+								{Name: "n3", Lag: 20},
+							}}},
+						{Name: "EVENTS", State: top.StreamState{Msgs: 500, Bytes: 50000, FirstSeq: 1, LastSeq: 500, Consumers: 1},
+							Config: &top.StreamConfig{Replicas: 1}},
+					}},
+					{Name: "B"},
+				},
+			}
+		}},
+		{"jetstream_consumers", func(v *View, stats *top.Stats) {
+			v.ListJetStream = true
+			v.JetStreamAccount = "A"
+			v.JetStreamStream = "ORDERS"
+			stats.Jsz = &top.Jsz{
+				Config:    &top.JetStreamConfig{MaxMemory: 1024 * 1024, MaxStore: 1024 * 1024 * 1024},
+				Streams:   1,
+				Consumers: 2,
+				AccountDetails: []*top.JSAccountDetail{
+					{Name: "A", Streams: []*top.StreamDetail{
+						{Name: "ORDERS", State: top.StreamState{Msgs: 1000, FirstSeq: 11, LastSeq: 1010, Consumers: 2},
+							Consumers: []*top.ConsumerInfo{
+								{Name: "billing", NumAckPending: 10, NumRedelivered: 2, NumPending: 600,
+									Delivered: top.SequenceInfo{Consumer: 400, Stream: 410},
+									AckFloor:  top.SequenceInfo{Consumer: 390, Stream: 400}},
+								{Name: "shipping", NumWaiting: 1,
+									Delivered: top.SequenceInfo{Consumer: 1000, Stream: 1010},
+									AckFloor:  top.SequenceInfo{Consumer: 1000, Stream: 1010}},
+							}},
+					}},
+				},
+			}
+		}},
+		{"accountz", func(v *View, stats *top.Stats) {
+			v.ListAccounts = true
+			stats.Accountz = &top.Accountz{
+				SystemAccount: "$SYS",
+				Accounts:      []string{"$G", "$SYS", "ORDERS"},
+			}
+		}},
+		{"accountz_detail", func(v *View, stats *top.Stats) {
+			v.ListAccounts = true
+			v.AccountDetail = "ORDERS"
+			acc := &top.AccountInfo{
+				Name: "ORDERS", NameTag: "orders", IssuerKey: "OABC", JetStream: true,
+				Clients: 3, Leafs: 1, Subs: 42,
+				Imports: []*top.ImportInfo{{Subject: "billing.>", Account: "BILLING", Type: "stream"}},
+				Exports: []*top.ExportInfo{
+					{Subject: "orders.status", Type: "service"},
+					{Subject: "orders.events", Type: "stream", Approved: []string{"SHIPPING"}},
+				},
+				Claims: &top.AccountClaims{},
+			}
+			acc.Claims.Nats.Limits = top.AccountLimits{Conn: 100, Leaf: -1, Subs: -1, Data: -1, Payload: 1024 * 1024, Imports: -1, Exports: 10}
+			stats.Accountz = &top.Accountz{Account: acc}
+		}},
+		{"alerts", func(v *View, stats *top.Stats) {
+			v.ListAlerts = true
+			at := time.Date(2026, 10, 15, 11, 50, 0, 0, time.UTC)
+			slow := &top.Alert{Condition: "slow_poll", Message: "polling took 1.2s, longer than the 1s refresh interval", Since: at}
+			stats.AlertEvents = []*top.AlertEvent{
+				{Time: at, Alert: slow},
+				{Time: at.Add(time.Minute), Alert: slow, Resolved: true},
+				{Time: at.Add(2 * time.Minute), Alert: stats.Alerts[0]},
+			}
+			v.Engine.Session.Update(stats)
+		}},
+		{"rtt", func(v *View, stats *top.Stats) {
+			v.DisplayRTT = true
+			stats.ExtConnz.Conns[0].RTT = "250µs"
+			stats.ExtConnz.Conns[1].RTT = "42ms"
+		}},
+		{"healthz", func(v *View, stats *top.Stats) {
+			stats.Healthz = &top.Healthz{Status: "unavailable", Error: "JetStream is not current with the meta leader"}
+		}},
+		{"conn_detail", func(v *View, stats *top.Stats) {
+			v.ConnDetail = 1
+			stats.Connz.Conns = stats.Connz.Conns[:1]
+			stats.Connz.NumConns = 1
+			stats.Connz.Conns[0].NumSubs = 2
+			stats.Connz.Conns[0].Pending = 4096
+			stats.Connz.Conns[0].Start = time.Date(2016, 10, 1, 10, 30, 15, 250e6, time.UTC)
+			stats.SubTraffic = []*top.SubTraffic{
+				{SubDetails: top.SubDetails{Subject: "orders.paid", Queue: "workers", Sid: "2", Msgs: 3000}, MsgsRate: 30, Share: 0.75, Pending: 3072},
+				{SubDetails: top.SubDetails{Subject: "orders.new", Sid: "1", Msgs: 1000, Max: 5000}, MsgsRate: 10, Share: 0.25, Pending: 1024},
+			}
+		}},
+		{"idle", func(v *View, stats *top.Stats) {
+			v.IdleOnly = true
+			v.Engine.IdleThreshold = 5 * time.Minute
+			stats.Connz.Conns = stats.Connz.Conns[:1]
+			stats.Connz.NumConns = 1
+		}},
+		{"totals", func(v *View, stats *top.Stats) {
+			v.Totals = true
+			stats.Connz.Total = 5
+		}},
+		{"kinds", func(v *View, stats *top.Stats) {
+			stats.ExtConnz.Conns[0].Kind = "Client"
+			stats.ExtConnz.Conns[0].RTT = "1.5ms"
+			stats.ExtConnz.Conns[1].Kind = "Client"
+			stats.ExtConnz.Conns[1].Type = "mqtt"
+		}},
+		{"columns", func(v *View, stats *top.Stats) {
+			v.Columns = []top.Column{
+				{Field: top.HostField, Header: "HOST", Width: 18},
+				{Field: "account", Header: "ACCOUNT", Width: 8},
+				{Field: "pending_bytes", Header: "PENDING", Width: 8, Format: top.PsizeFormat},
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		v := NewView(top.NewEngine("127.0.0.1", 8222, 1024, 1))
+		stats := testStats()
+		test.setup(v, stats)
+		checkGolden(t, test.name, v.Paragraph(stats))
+	}
+}
+
+func TestStripColors(t *testing.T) {
+	v := NewView(top.NewEngine("127.0.0.1", 8222, 1024, 1))
+	v.Colors = true
+	text := "Health: " + v.colored("ok", "green")
+	if text != "Health: [ok](fg-green)" {
+		t.Fatalf("Wrong colored text, got: %q", text)
+	}
+	if plain := StripColors(text); plain != "Health: ok" {
+		t.Fatalf("Wrong text without colors, got: %q", plain)
+	}
+}
+
+func TestExportColors(t *testing.T) {
+	v := NewView(top.NewEngine("127.0.0.1", 8222, 1024, 1))
+	v.Colors = true
+	stats := testStats()
+	stats.Healthz = &top.Healthz{Status: "ok"}
+	text := v.Text(stats) + v.Table(stats).Markup()
+
+	// Plain connections are colored as a cell of the table
+	if !strings.Contains(text, "[plain](fg-red)") {
+		t.Fatalf("Expected the plain connections to be colored, got:\n%s", text)
+	}
+	if StripColors(text) != StripColors(v.Paragraph(stats)) {
+		t.Fatalf("Expected the colors to be all that differs from the paragraph, got:\n%s", text)
+	}
+	if escaped := HTML("[<b>](fg-red) & c"); escaped != `<span style="color: red">&lt;b&gt;</span> &amp; c` {
+		t.Fatalf("Wrong escaping of colored html, got: %s", escaped)
+	}
+	checkGolden(t, "export_html", HTML(text))
+	checkGolden(t, "export_ansi", ANSI(text))
+}
+
+func TestServerInfo(t *testing.T) {
+	stats := testStats()
+	stats.Varz.Info = &server.Info{ID: "NDJWE4", Version: "0.9.2", GoVersion: "go1.7", Host: "0.0.0.0", TLSRequired: true}
+	stats.Varz.Port = 4222
+	stats.Varz.Options = &server.Options{
+		MaxConn: 65536, MaxPending: 256 * 1024 * 1024, MaxControlLine: 1024,
+		PingInterval: 2 * time.Minute, MaxPingsOut: 2, AuthTimeout: 1,
+		HTTPHost: "0.0.0.0", HTTPPort: 8222,
+	}
+	stats.Varz.MaxPayload = 1024 * 1024
+	stats.ExtVarz.Name = "east-1"
+	stats.ExtVarz.Cluster = &top.ClusterVarz{Name: "east", Host: "0.0.0.0", Port: 6222, URLs: []string{"east-2:6222"}}
+	stats.ExtVarz.Gateway.Host = "0.0.0.0"
+	stats.ExtVarz.Gateway.Port = 7222
+	checkGolden(t, "info", ServerInfo(stats))
+
+	// Only the sections reported by the server are shown
+	checkGolden(t, "info_empty", ServerInfo(&top.Stats{Varz: &server.Varz{}}))
+}
+
+func TestHelp(t *testing.T) {
+	checkGolden(t, "help", Help(top.DefaultKeyBindings()))
+}
+
+func TestTopFooter(t *testing.T) {
+	expected := "o Sort  n Limit  s Subs  e Export  ? Help  q Quit  Up/Down Select"
+	got := TopFooter(top.DefaultKeyBindings())
+	if got != expected {
+		t.Fatalf("Wrong footer. expected: %q, got: %q", expected, got)
+	}
+
+	keys := top.DefaultKeyBindings()
+	delete(keys, 'e')
+	keys['x'] = top.QuitAction
+	delete(keys, 'q')
+	expected = "o Sort  n Limit  s Subs  ? Help  x Quit  Up/Down Select"
+	got = TopFooter(keys)
+	if got != expected {
+		t.Fatalf("Wrong footer. expected: %q, got: %q", expected, got)
+	}
+}
+
+func TestTextScreen(t *testing.T) {
+	screen := NewTextScreen(10, 8)
+	screen.SetText("line 1\nline 2 is too long\n\n\n\n\nstats\n\nconns\n")
+	screen.SetPrompt("sort by:")
+	screen.Render()
+
+	expected := "line 1\nline 2 is \n\n\n\n\nsort by:\n"
+	if screen.String() != expected {
+		t.Fatalf("Wrong screen. expected: %q, got: %q", expected, screen.String())
+	}
+
+	screen.SetFooter("q Quit")
+	screen.Render()
+
+	expected = "line 1\nline 2 is \n\n\n\n\nsort by:\nq Quit"
+	if screen.String() != expected {
+		t.Fatalf("Wrong screen. expected: %q, got: %q", expected, screen.String())
+	}
+
+	screen.SetPrompt("")
+	screen.SetFooter("")
+	screen.Resize(0, 0)
+	screen.Render()
+
+	expected = "line 1\nline 2 is too long\n\n\n\n\nstats\n\nconns\n"
+	if screen.String() != expected {
+		t.Fatalf("Wrong screen. expected: %q, got: %q", expected, screen.String())
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable([]string{"HOST", "CID"}, []int{6})
+	table.AddRow(1, Cell{Text: "127.0.0.1:4222"}, Cell{Text: "1"})
+	table.AddRow(2, Cell{Text: "a"}, Cell{Text: "12345"})
+
+	expected := []int{14, 5}
+	for i, w := range expected {
+		if table.Widths[i] != w {
+			t.Fatalf("Wrong width of column %d. expected: %d, got: %d", i, w, table.Widths[i])
+		}
+	}
+
+	expectedText := "  HOST            CID  \n  127.0.0.1:4222  1    \n  a               12345\n"
+	if table.String() != expectedText {
+		t.Fatalf("Wrong table. expected: %q, got: %q", expectedText, table.String())
+	}
+
+	if table.SelectedIndex() != -1 {
+		t.Fatalf("Expected no row to be selected, got: %d", table.SelectedIndex())
+	}
+	for _, test := range []struct {
+		move     int
+		selected uint64
+	}{
+		{1, 1},
+		{1, 2},
+		{1, 2},
+		{-5, 1},
+	} {
+		table.Move(test.move)
+		if table.Selected != test.selected {
+			t.Fatalf("Wrong selected row. expected: %d, got: %d", test.selected, table.Selected)
+		}
+	}
+}
+
+func TestHighlightChanges(t *testing.T) {
+	prev := NewTable([]string{"CID", "SUBS", "UPTIME"}, nil)
+	prev.AddRow(1, Cell{Text: "1"}, Cell{Text: "1"}, Cell{Text: "1s"})
+	prev.AddRow(2, Cell{Text: "2"}, Cell{Text: "1"}, Cell{Text: "1s"})
+
+	table := NewTable([]string{"CID", "SUBS", "UPTIME"}, nil)
+	table.AddRow(1, Cell{Text: "1"}, Cell{Text: "2"}, Cell{Text: "2s"})
+	table.AddRow(2, Cell{Text: "2"}, Cell{Text: "1"}, Cell{Text: "2s"})
+	table.AddRow(3, Cell{Text: "3"}, Cell{Text: "1"}, Cell{Text: "1s"})
+	table.HighlightChanges(prev, ui.ColorYellow)
+
+	for i, row := range table.Rows {
+		for j, cell := range row.Cells {
+			expected := ui.ColorDefault
+			if i == 0 && j == 1 {
+				expected = ui.ColorYellow
+			}
+			if cell.Bg != expected {
+				t.Fatalf("Wrong highlight of cell %d of row %d. expected: %v, got: %v", j, i, expected, cell.Bg)
+			}
+		}
+	}
+}
+
+func TestShare(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer l.Close()
+
+	share := NewShare()
+	go share.Serve(l)
+	share.Update("first\nscreen\n")
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to the share: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	// The latest screen is sent on connecting, then every update
+	expected := clearScreen + "first\r\nscreen\r\n"
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != expected {
+		t.Fatalf("Wrong screen on connecting. expected: %q, got: %q (%v)", expected, buf, err)
+	}
+
+	share.Update("second\n")
+	expected = clearScreen + "second\r\n"
+	buf = make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != expected {
+		t.Fatalf("Wrong screen on update. expected: %q, got: %q (%v)", expected, buf, err)
+	}
+
+	// Viewers are dropped when disconnecting
+	conn.Close()
+	for i := 0; share.Viewers() > 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := share.Viewers(); n != 0 {
+		t.Fatalf("Expected no viewers after disconnecting, got: %d", n)
+	}
+}
+
+func TestShareStalledViewer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer l.Close()
+
+	share := NewShare()
+	go share.Serve(l)
+
+	// A viewer that never reads fills up its buffers right away
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect to the share: %v", err)
+	}
+	defer conn.Close()
+	for i := 0; share.Viewers() == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	screen := strings.Repeat("x", 1<<20)
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		share.Update(screen)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("Expected updates not to wait on stalled viewers, took: %v", took)
+	}
+}
+
+func TestUptimeCell(t *testing.T) {
+	v := NewView(top.NewEngine("127.0.0.1", 8222, 1024, 1))
+	if cell := v.uptimeCell("5s"); cell.Fg != ui.ColorDefault {
+		t.Fatalf("Expected no color unless enabled, got: %v", cell.Fg)
+	}
+
+	v.AgeColors = true
+	for _, test := range []struct {
+		uptime string
+		color  ui.Attribute
+	}{
+		{"5s", ui.ColorRed},
+		{"12m3s", ui.ColorYellow},
+		{"3h2m", ui.ColorGreen},
+		{"2d4h", ui.ColorCyan},
+		{"", ui.ColorDefault},
+	} {
+		if cell := v.uptimeCell(test.uptime); cell.Fg != test.color {
+			t.Fatalf("Wrong color for uptime %q. expected: %v, got: %v", test.uptime, test.color, cell.Fg)
+		}
+	}
+}
+
+func TestConsumersAckPendingCell(t *testing.T) {
+	v := NewView(top.NewEngine("127.0.0.1", 8222, 1024, 1))
+	v.ListJetStream = true
+	v.JetStreamAccount = "A"
+	v.JetStreamStream = "ORDERS"
+	stats := &top.Stats{Jsz: &top.Jsz{AccountDetails: []*top.JSAccountDetail{
+		{Name: "A", Streams: []*top.StreamDetail{{Name: "ORDERS", Consumers: []*top.ConsumerInfo{
+			{Name: "billing", NumAckPending: 950, Config: &top.ConsumerConfig{MaxAckPending: 1000}},
+			{Name: "shipping", NumAckPending: 10, Config: &top.ConsumerConfig{MaxAckPending: 1000}},
+		}}}},
+	}}}
+
+	// Acks pending are only highlighted past the threshold of the alert
+	for _, test := range []struct {
+		threshold float64
+		colors    []ui.Attribute
+	}{
+		{0, []ui.Attribute{ui.ColorDefault, ui.ColorDefault}},
+		{90, []ui.Attribute{ui.ColorRed, ui.ColorDefault}},
+	} {
+		v.Engine.AckPending = test.threshold
+		for i, row := range v.Table(stats).Rows {
+			if cell := row.Cells[3]; cell.Fg != test.colors[i] {
+				t.Fatalf("Wrong color for %s acks pending at %g%%. expected: %v, got: %v", row.Cells[0].Text, test.threshold, test.colors[i], cell.Fg)
+			}
+		}
+	}
+}